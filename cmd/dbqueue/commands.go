@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/transfer"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+type attributeFlag map[string]string
+
+func (p attributeFlag) String() string {
+	pairs := make([]string, 0, len(p))
+	for key, value := range p {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p attributeFlag) Set(value string) error {
+	key, attribute, found := strings.Cut(value, "=")
+	if !found {
+		return fmt.Errorf("attribute %q is not in key=value form", value)
+	}
+	p[key] = attribute
+	return nil
+}
+
+type queueResult struct {
+	Queue       string `json:"queue"`
+	Action      string `json:"action"`
+	Count       *int   `json:"count,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Left        *int   `json:"left,omitempty"`
+}
+
+type statsResult struct {
+	Queue            string  `json:"queue"`
+	Depth            int     `json:"depth"`
+	Visible          int     `json:"visible"`
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+}
+
+func runCreate(ctx context.Context, cli *cli, args []string) error {
+	names, parseErr := cli.setup(ctx, flag.NewFlagSet("create", flag.ContinueOnError), args, "<queue>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	if _, createErr := cli.engine.CreateQueue(ctx, names[0]); createErr != nil {
+		return createErr
+	}
+	return cli.printAction(queueResult{Queue: names[0], Action: "created"})
+}
+
+func runList(ctx context.Context, cli *cli, args []string) error {
+	if _, parseErr := cli.setup(ctx, flag.NewFlagSet("list", flag.ContinueOnError), args); parseErr != nil {
+		return parseErr
+	}
+
+	names, listErr := cli.engine.ListQueues(ctx)
+	if listErr != nil {
+		return listErr
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	return cli.print(names, func(w *tabwriter.Writer) {
+		for _, name := range names {
+			fmt.Fprintln(w, name)
+		}
+	})
+}
+
+func runDelete(ctx context.Context, cli *cli, args []string) error {
+	names, parseErr := cli.setup(ctx, flag.NewFlagSet("delete", flag.ContinueOnError), args, "<queue>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	if deleteErr := cli.engine.DeleteQueue(ctx, names[0]); deleteErr != nil {
+		return deleteErr
+	}
+	return cli.printAction(queueResult{Queue: names[0], Action: "deleted"})
+}
+
+func runPurge(ctx context.Context, cli *cli, args []string) error {
+	names, parseErr := cli.setup(ctx, flag.NewFlagSet("purge", flag.ContinueOnError), args, "<queue>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	if purgeErr := cli.engine.PurgeQueue(ctx, names[0]); purgeErr != nil {
+		return purgeErr
+	}
+	return cli.printAction(queueResult{Queue: names[0], Action: "purged"})
+}
+
+func runSend(ctx context.Context, cli *cli, args []string) error {
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	file := flags.String("file", "", "read the payload from a file instead of stdin")
+	priority := flags.Uint("priority", 0, "message priority")
+	deduplicationID := flags.String("deduplication-id", "", "deduplication ID, generated when empty")
+	delay := flags.Duration("delay", 0, "delay before the message becomes visible")
+	attributes := attributeFlag{}
+	flags.Var(attributes, "attribute", "message attribute as key=value, can be repeated")
+	names, parseErr := cli.setup(ctx, flags, args, "<queue>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	queue, openErr := cli.engine.OpenQueue(ctx, names[0])
+	if openErr != nil {
+		return openErr
+	}
+
+	payload, readErr := cli.read(*file)
+	if readErr != nil {
+		return readErr
+	}
+
+	message := &types.Message{
+		Payload:      payload,
+		Priority:     uint32(*priority),
+		VisibleAfter: common.Ptr(common.VisibleAfter(time.Now(), *delay)),
+		Attributes:   attributes,
+	}
+	if *deduplicationID != "" {
+		message.DeduplicationID = deduplicationID
+	}
+	if sendErr := queue.SendMessage(ctx, message); sendErr != nil {
+		return sendErr
+	}
+	return cli.printAction(queueResult{Queue: names[0], Action: "sent", Count: common.Ptr(1)})
+}
+
+func runReceive(ctx context.Context, cli *cli, args []string) error {
+	flags := flag.NewFlagSet("receive", flag.ContinueOnError)
+	maxMessages := flags.Int("max", 1, "maximum number of messages to receive")
+	visibilityTimeout := flags.Duration("visibility-timeout", 30*time.Second, "how long received messages stay hidden")
+	deleteReceived := flags.Bool("delete", false, "delete the messages after printing them")
+	names, parseErr := cli.setup(ctx, flags, args, "<queue>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	queue, openErr := cli.engine.OpenQueue(ctx, names[0])
+	if openErr != nil {
+		return openErr
+	}
+
+	messages, claimErr := queue.ClaimMessages(ctx, types.ReceiveMessageOptions{
+		MaxNumberOfMessages: maxMessages,
+		VisibilityTimeout:   visibilityTimeout,
+	})
+	if claimErr != nil {
+		return claimErr
+	}
+
+	records := make([]types.ExportRecord, 0, len(messages))
+	ids := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		records = append(records, types.ExportRecord{
+			Version:         types.ExportFormatVersion,
+			ID:              message.ID,
+			Payload:         message.Payload,
+			Priority:        message.Priority,
+			DeduplicationID: message.DeduplicationID,
+			VisibleAfter:    *message.VisibleAfter,
+			Retrieval:       message.Retrieval,
+			CreatedAt:       message.CreatedAt,
+			Attributes:      message.Attributes,
+		})
+		ids = append(ids, message.ID)
+	}
+	if printErr := cli.printRecords(records); printErr != nil {
+		return printErr
+	}
+
+	if *deleteReceived {
+		return queue.DeleteMessageBatch(ctx, ids)
+	}
+	return nil
+}
+
+func runPeek(ctx context.Context, cli *cli, args []string) error {
+	flags := flag.NewFlagSet("peek", flag.ContinueOnError)
+	maxMessages := flags.Int("max", 10, "maximum number of messages to show")
+	after := flags.Uint("after", 0, "only show messages with a greater ID")
+	names, parseErr := cli.setup(ctx, flags, args, "<queue>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	queue, openErr := cli.engine.OpenQueue(ctx, names[0])
+	if openErr != nil {
+		return openErr
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		_, exportErr := queue.Export(ctx, writer, types.ExportOptions{
+			AfterID: common.Ptr(*after),
+			Limit:   maxMessages,
+		})
+		writer.CloseWithError(exportErr)
+	}()
+
+	records, decodeErr := decodeRecords(reader)
+	if decodeErr != nil {
+		return decodeErr
+	}
+	return cli.printRecords(records)
+}
+
+func runStats(ctx context.Context, cli *cli, args []string) error {
+	names, parseErr := cli.setup(ctx, flag.NewFlagSet("stats", flag.ContinueOnError), args, "<queue>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	queue, openErr := cli.engine.OpenQueue(ctx, names[0])
+	if openErr != nil {
+		return openErr
+	}
+
+	stats, statsErr := queue.Stats(ctx)
+	if statsErr != nil {
+		return statsErr
+	}
+
+	result := statsResult{
+		Queue:            names[0],
+		Depth:            stats.Depth,
+		Visible:          stats.Visible,
+		OldestAgeSeconds: stats.OldestAge.Seconds(),
+	}
+	return cli.print(result, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "QUEUE\tDEPTH\tVISIBLE\tOLDEST AGE")
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", result.Queue, result.Depth, result.Visible,
+			stats.OldestAge.Truncate(time.Second))
+	})
+}
+
+func runRedrive(ctx context.Context, cli *cli, args []string) error {
+	flags := flag.NewFlagSet("redrive", flag.ContinueOnError)
+	batchSize := flags.Int("batch-size", 500, "messages moved per batch")
+	retrievalAbove := flags.Int("retrieval-above", -1, "only move messages received more than this many times")
+	includeHidden := flags.Bool("include-hidden", false, "also move messages that are in flight or delayed")
+	names, parseErr := cli.setup(ctx, flags, args, "<source>", "<destination>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	source, sourceErr := cli.engine.OpenQueue(ctx, names[0])
+	if sourceErr != nil {
+		return sourceErr
+	}
+	destination, destinationErr := cli.engine.OpenQueue(ctx, names[1])
+	if destinationErr != nil {
+		return destinationErr
+	}
+
+	result, transferErr := transfer.Transfer(ctx, source, destination, transfer.Options{
+		Move:      common.Ptr(true),
+		BatchSize: batchSize,
+		Select: func(record types.ExportRecord) bool {
+			if !*includeHidden && record.VisibleAfter > time.Now().Unix() {
+				return false
+			}
+			return int64(record.Retrieval) > int64(*retrievalAbove)
+		},
+	})
+	if transferErr != nil {
+		return transferErr
+	}
+	return cli.printAction(queueResult{Queue: names[0], Action: "moved", Count: &result.Count, Destination: names[1],
+		Left: common.Ptr(result.Excluded + result.InFlight)})
+}
+
+func runExport(ctx context.Context, cli *cli, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := flags.String("file", "", "write the export to a file instead of stdout")
+	names, parseErr := cli.setup(ctx, flags, args, "<queue>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	queue, openErr := cli.engine.OpenQueue(ctx, names[0])
+	if openErr != nil {
+		return openErr
+	}
+
+	if *file == "" {
+		_, exportErr := queue.Export(ctx, cli.stdout)
+		return exportErr
+	}
+
+	output, createErr := os.Create(*file)
+	if createErr != nil {
+		return createErr
+	}
+	exported, exportErr := queue.Export(ctx, output)
+	if closeErr := output.Close(); exportErr == nil {
+		exportErr = closeErr
+	}
+	if exportErr != nil {
+		return exportErr
+	}
+	return cli.printAction(queueResult{Queue: names[0], Action: "exported", Count: &exported})
+}
+
+func runImport(ctx context.Context, cli *cli, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := flags.String("file", "", "read the export from a file instead of stdin")
+	batchSize := flags.Int("batch-size", 500, "messages inserted per transaction")
+	names, parseErr := cli.setup(ctx, flags, args, "<queue>")
+	if parseErr != nil {
+		return parseErr
+	}
+
+	queue, openErr := cli.engine.OpenQueue(ctx, names[0])
+	if openErr != nil {
+		return openErr
+	}
+
+	input := cli.stdin
+	if *file != "" {
+		opened, openFileErr := os.Open(*file)
+		if openFileErr != nil {
+			return openFileErr
+		}
+		defer opened.Close()
+		input = opened
+	}
+
+	imported, importErr := queue.Import(ctx, input, types.ImportOptions{BatchSize: batchSize})
+	if importErr != nil {
+		return importErr
+	}
+	return cli.printAction(queueResult{Queue: names[0], Action: "imported", Count: &imported})
+}
+
+func (p *cli) read(file string) ([]byte, error) {
+	if file == "" {
+		return io.ReadAll(p.stdin)
+	}
+	return os.ReadFile(file)
+}
+
+func (p *cli) printAction(result queueResult) error {
+	return p.print(result, func(w *tabwriter.Writer) {
+		if result.Destination != "" {
+			fmt.Fprintf(w, "%s %d message(s) from %s to %s, left %d\n", result.Action, *result.Count, result.Queue,
+				result.Destination, *result.Left)
+			return
+		}
+		if result.Count != nil {
+			fmt.Fprintf(w, "%s %d message(s) in %s\n", result.Action, *result.Count, result.Queue)
+			return
+		}
+		fmt.Fprintf(w, "%s %s\n", result.Action, result.Queue)
+	})
+}
+
+func (p *cli) printRecords(records []types.ExportRecord) error {
+	if p.output == outputJSON {
+		for _, record := range records {
+			if encodeErr := p.print(record, nil); encodeErr != nil {
+				return encodeErr
+			}
+		}
+		return nil
+	}
+
+	return p.print(records, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "ID\tPRIORITY\tRETRIEVAL\tVISIBLE AFTER\tDEDUPLICATION ID\tPAYLOAD")
+		for _, record := range records {
+			var deduplicationID string
+			if record.DeduplicationID != nil {
+				deduplicationID = *record.DeduplicationID
+			}
+			fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\t%s\n", record.ID, record.Priority, record.Retrieval,
+				time.Unix(record.VisibleAfter, 0).Format(time.RFC3339), deduplicationID, printable(record.Payload))
+		}
+	})
+}
+
+func printable(payload []byte) string {
+	if utf8.Valid(payload) {
+		return fmt.Sprintf("%q", payload)
+	}
+	return "base64:" + base64.StdEncoding.EncodeToString(payload)
+}
+
+func decodeRecords(reader io.Reader) ([]types.ExportRecord, error) {
+	records := []types.ExportRecord{}
+	decoder := json.NewDecoder(reader)
+	for {
+		var record types.ExportRecord
+		decodeErr := decoder.Decode(&record)
+		if errors.Is(decodeErr, io.EOF) {
+			return records, nil
+		}
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		records = append(records, record)
+	}
+}
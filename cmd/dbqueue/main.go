@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const dsnEnvironment = "DBQUEUE_DSN"
+
+var errUsage = errors.New("invalid usage")
+
+type outputMode string
+
+const (
+	outputText outputMode = "text"
+	outputJSON outputMode = "json"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, cli *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"create":  {usage: "create <queue>", run: runCreate},
+	"list":    {usage: "list", run: runList},
+	"delete":  {usage: "delete <queue>", run: runDelete},
+	"purge":   {usage: "purge <queue>", run: runPurge},
+	"send":    {usage: "send [flags] <queue>", run: runSend},
+	"receive": {usage: "receive [flags] <queue>", run: runReceive},
+	"peek":    {usage: "peek [flags] <queue>", run: runPeek},
+	"stats":   {usage: "stats <queue>", run: runStats},
+	"redrive": {usage: "redrive [flags] <source> <destination>", run: runRedrive},
+	"export":  {usage: "export [flags] <queue>", run: runExport},
+	"import":  {usage: "import [flags] <queue>", run: runImport},
+}
+
+var aliases = map[string]string{
+	"move": "redrive",
+}
+
+type cli struct {
+	usage  string
+	dsn    string
+	engine types.Engine
+	output outputMode
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if runErr := run(ctx, os.Args[1:], os.Stdin, os.Stdout); runErr != nil {
+		if errors.Is(runErr, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, "dbqueue:", runErr)
+		if errors.Is(runErr, errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("dbqueue", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: dbqueue [-dsn url] [-output text|json] <command> [flags] [arguments]")
+		fmt.Fprintln(flags.Output(), "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(flags.Output(), "  "+commands[name].usage)
+		}
+		for alias, name := range aliases {
+			fmt.Fprintf(flags.Output(), "  %s is an alias of %s\n", alias, name)
+		}
+		fmt.Fprintln(flags.Output(), "\nflags:")
+		flags.PrintDefaults()
+	}
+	dsn := flags.String("dsn", os.Getenv(dsnEnvironment), "database URL, defaults to $"+dsnEnvironment)
+	output := flags.String("output", string(outputText), "output format, text or json")
+	if parseErr := flags.Parse(args); parseErr != nil {
+		return usageErr(parseErr)
+	}
+
+	if *output != string(outputText) && *output != string(outputJSON) {
+		return fmt.Errorf("%w: unknown output format %q", errUsage, *output)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+	name := flags.Arg(0)
+	if alias, found := aliases[name]; found {
+		name = alias
+	}
+	selected, found := commands[name]
+	if !found {
+		flags.Usage()
+		return fmt.Errorf("%w: unknown command %q", errUsage, flags.Arg(0))
+	}
+
+	selectedCLI := &cli{
+		usage:  selected.usage,
+		dsn:    *dsn,
+		output: outputMode(*output),
+		stdin:  stdin,
+		stdout: stdout,
+	}
+	runErr := selected.run(ctx, selectedCLI, flags.Args()[1:])
+	if selectedCLI.engine != nil {
+		return errors.Join(runErr, selectedCLI.engine.Close())
+	}
+	return runErr
+}
+
+// setup parses the command flags and connects to the database. Flags are accepted before, between
+// and after the positional arguments, so both "send -priority 1 jobs" and "send jobs -priority 1" work.
+func (p *cli) setup(ctx context.Context, flags *flag.FlagSet, args []string, names ...string) ([]string, error) {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: dbqueue %s\n", p.usage)
+		flags.PrintDefaults()
+	}
+
+	var positional []string
+	for {
+		if parseErr := flags.Parse(args); parseErr != nil {
+			return nil, usageErr(parseErr)
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	if len(positional) != len(names) {
+		flags.Usage()
+		return nil, fmt.Errorf("%w: expected %s", errUsage, strings.Join(names, " "))
+	}
+
+	if p.dsn == "" {
+		return nil, fmt.Errorf("%w: -dsn or $%s is required", errUsage, dsnEnvironment)
+	}
+	engine, openErr := dbqueue.Open(ctx, p.dsn)
+	if openErr != nil {
+		return nil, openErr
+	}
+	p.engine = engine
+
+	return positional, nil
+}
+
+func usageErr(parseErr error) error {
+	if errors.Is(parseErr, flag.ErrHelp) {
+		return parseErr
+	}
+	return fmt.Errorf("%w: %w", errUsage, parseErr)
+}
+
+func (p *cli) print(value any, text func(w *tabwriter.Writer)) error {
+	if p.output == outputJSON {
+		return json.NewEncoder(p.stdout).Encode(value)
+	}
+
+	writer := tabwriter.NewWriter(p.stdout, 0, 4, 2, ' ', 0)
+	text(writer)
+	return writer.Flush()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Setup_SQLite(t *testing.T) {
+	// given
+	ctx := context.Background()
+	dsn := sqliteDSN(t)
+	newFlags := func() (*flag.FlagSet, *uint, attributeFlag) {
+		flags := flag.NewFlagSet("send", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		attributes := attributeFlag{}
+		flags.Var(attributes, "attribute", "")
+		return flags, flags.Uint("priority", 0, ""), attributes
+	}
+	setup := func(flags *flag.FlagSet, args ...string) ([]string, error) {
+		selected := &cli{usage: "send [flags] <queue>", dsn: dsn}
+		names, setupErr := selected.setup(ctx, flags, args, "<queue>")
+		if selected.engine != nil {
+			t.Cleanup(func() {
+				assert.NoError(t, selected.engine.Close())
+			})
+		}
+		return names, setupErr
+	}
+
+	// when
+	interleavedFlags, priority, attributes := newFlags()
+	interleaved, interleavedErr := setup(interleavedFlags, "-priority", "2", "jobs", "-attribute", "a=b",
+		"-attribute", "c=d")
+	trailingFlags, trailingPriority, _ := newFlags()
+	trailing, trailingErr := setup(trailingFlags, "jobs", "-priority", "3")
+	extraFlags, _, _ := newFlags()
+	_, extraErr := setup(extraFlags, "jobs", "-priority", "1", "other")
+	missingFlags, _, _ := newFlags()
+	_, missingErr := setup(missingFlags, "-priority", "1")
+	invalidFlags, _, _ := newFlags()
+	_, invalidErr := setup(invalidFlags, "jobs", "-attribute", "novalue")
+
+	// then
+	assert.NoError(t, interleavedErr)
+	assert.Equal(t, []string{"jobs"}, interleaved)
+	assert.Equal(t, uint(2), *priority)
+	assert.Equal(t, attributeFlag{"a": "b", "c": "d"}, attributes)
+	assert.NoError(t, trailingErr)
+	assert.Equal(t, []string{"jobs"}, trailing)
+	assert.Equal(t, uint(3), *trailingPriority)
+	assert.ErrorIs(t, extraErr, errUsage)
+	assert.ErrorIs(t, missingErr, errUsage)
+	assert.ErrorIs(t, invalidErr, errUsage)
+}
+func Test_TextOutput_SQLite(t *testing.T) {
+	// given
+	dsn := sqliteDSN(t)
+
+	// when
+	created, createErr := runCLI(dsn, "", "create", "jobs")
+	sent, sendErr := runCLI(dsn, "hello", "send", "jobs", "-priority", "4", "-deduplication-id", "greeting",
+		"-delay", "-2s")
+	listed, listErr := runCLI(dsn, "", "list")
+	peeked, peekErr := runCLI(dsn, "", "peek", "jobs")
+	stats, statsErr := runCLI(dsn, "", "stats", "jobs")
+	_, unknownErr := runCLI(dsn, "", "unknown")
+
+	// then
+	assert.NoError(t, createErr)
+	assert.Equal(t, "created jobs\n", created)
+	assert.NoError(t, sendErr)
+	assert.Equal(t, "sent 1 message(s) in jobs\n", sent)
+	assert.NoError(t, listErr)
+	assert.Equal(t, "jobs\n", listed)
+	assert.NoError(t, peekErr)
+	lines := strings.Split(strings.TrimSpace(peeked), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, []string{"ID", "PRIORITY", "RETRIEVAL", "VISIBLE", "AFTER", "DEDUPLICATION", "ID", "PAYLOAD"},
+		strings.Fields(lines[0]))
+	row := strings.Fields(lines[1])
+	assert.Equal(t, []string{"1", "4", "0"}, row[:3])
+	assert.Equal(t, []string{"greeting", `"hello"`}, row[4:])
+	assert.NoError(t, statsErr)
+	assert.Equal(t, []string{"jobs", "1", "1"}, strings.Fields(strings.Split(stats, "\n")[1])[:3])
+	assert.ErrorIs(t, unknownErr, errUsage)
+}
+func Test_JSONOutput_SQLite(t *testing.T) {
+	// given
+	dsn := sqliteDSN(t)
+	decode := func(output string, value any) {
+		assert.NoError(t, json.Unmarshal([]byte(output), value))
+	}
+
+	// when
+	created, createErr := runCLI(dsn, "", "-output", "json", "create", "jobs")
+	_, sendErr := runCLI(dsn, "hello", "-output", "json", "send", "-attribute", "tenant=acme", "jobs",
+		"-delay", "-2s")
+	stats, statsErr := runCLI(dsn, "", "-output", "json", "stats", "jobs")
+	received, receiveErr := runCLI(dsn, "", "-output", "json", "receive", "-delete", "jobs")
+	emptied, emptiedErr := runCLI(dsn, "", "-output", "json", "stats", "jobs")
+
+	// then
+	assert.NoError(t, createErr)
+	var createdResult queueResult
+	decode(created, &createdResult)
+	assert.Equal(t, queueResult{Queue: "jobs", Action: "created"}, createdResult)
+	assert.NoError(t, sendErr)
+	assert.NoError(t, statsErr)
+	var statsBefore statsResult
+	decode(stats, &statsBefore)
+	assert.Equal(t, 1, statsBefore.Depth)
+	assert.Equal(t, 1, statsBefore.Visible)
+	assert.NoError(t, receiveErr)
+	var record types.ExportRecord
+	decode(received, &record)
+	assert.Equal(t, []byte("hello"), record.Payload)
+	assert.Equal(t, uint32(1), record.Retrieval)
+	assert.Equal(t, map[string]string{"tenant": "acme"}, record.Attributes)
+	assert.NoError(t, emptiedErr)
+	var statsAfter statsResult
+	decode(emptied, &statsAfter)
+	assert.Equal(t, 0, statsAfter.Depth)
+}
+func Test_Redrive_SQLite(t *testing.T) {
+	// given
+	dsn := sqliteDSN(t)
+	for _, name := range []string{"jobs", "failed"} {
+		_, createErr := runCLI(dsn, "", "create", name)
+		assert.NoError(t, createErr)
+	}
+	for i, payload := range []string{"in flight", "visible", "delayed"} {
+		delay := "-2s"
+		if payload == "delayed" {
+			delay = "1h"
+		}
+		_, sendErr := runCLI(dsn, payload, "send", "-priority", string(rune('3'-i)), "-delay", delay, "failed")
+		assert.NoError(t, sendErr)
+	}
+	_, receiveErr := runCLI(dsn, "", "receive", "-visibility-timeout", "1h", "failed")
+
+	// when
+	visible, visibleErr := runCLI(dsn, "", "-output", "json", "redrive", "failed", "jobs")
+	retried, retriedErr := runCLI(dsn, "", "move", "-include-hidden", "-retrieval-above", "0", "failed", "jobs")
+	remaining, remainingErr := runCLI(dsn, "", "-output", "json", "peek", "failed")
+
+	// then
+	assert.NoError(t, receiveErr)
+	assert.NoError(t, visibleErr)
+	var visibleResult queueResult
+	assert.NoError(t, json.Unmarshal([]byte(visible), &visibleResult))
+	assert.Equal(t, 1, *visibleResult.Count)
+	assert.Equal(t, 2, *visibleResult.Left)
+	assert.NoError(t, retriedErr)
+	assert.Equal(t, "moved 1 message(s) from failed to jobs, left 1\n", retried)
+	assert.NoError(t, remainingErr)
+	var record types.ExportRecord
+	assert.NoError(t, json.Unmarshal([]byte(remaining), &record))
+	assert.Equal(t, []byte("delayed"), record.Payload)
+}
+func sqliteDSN(t *testing.T) string {
+	file, fileErr := os.CreateTemp("", "")
+	if fileErr != nil {
+		t.Fatal(fileErr)
+	}
+	assert.NoError(t, file.Close())
+	return "sqlite://" + file.Name() + "?_journal_mode=WAL"
+}
+func runCLI(dsn string, stdin string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	runErr := run(context.Background(), append([]string{"-dsn", dsn}, args...), strings.NewReader(stdin), &stdout)
+	return stdout.String(), runErr
+}
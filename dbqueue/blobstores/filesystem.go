@@ -0,0 +1,81 @@
+package blobstores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type FilesystemBlobStore struct {
+	directory string
+}
+
+func NewFilesystemBlobStore(directory string) (*FilesystemBlobStore, error) {
+	if mkdirErr := os.MkdirAll(directory, 0o700); mkdirErr != nil {
+		return nil, mkdirErr
+	}
+	return &FilesystemBlobStore{
+		directory: directory,
+	}, nil
+}
+
+func (f *FilesystemBlobStore) Put(_ context.Context, key string, data []byte) error {
+	path, pathErr := f.path(key)
+	if pathErr != nil {
+		return pathErr
+	}
+
+	file, createErr := os.CreateTemp(f.directory, ".tmp-*")
+	if createErr != nil {
+		return createErr
+	}
+
+	if _, writeErr := file.Write(data); writeErr != nil {
+		return errors.Join(writeErr, file.Close(), os.Remove(file.Name()))
+	}
+	if closeErr := file.Close(); closeErr != nil {
+		return errors.Join(closeErr, os.Remove(file.Name()))
+	}
+
+	if renameErr := os.Rename(file.Name(), path); renameErr != nil {
+		return errors.Join(renameErr, os.Remove(file.Name()))
+	}
+
+	return nil
+}
+
+func (f *FilesystemBlobStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, pathErr := f.path(key)
+	if pathErr != nil {
+		return nil, pathErr
+	}
+
+	data, readErr := os.ReadFile(path)
+	if errors.Is(readErr, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", types.ErrBlobNotFound, key)
+	}
+	return data, readErr
+}
+
+func (f *FilesystemBlobStore) Delete(_ context.Context, key string) error {
+	path, pathErr := f.path(key)
+	if pathErr != nil {
+		return pathErr
+	}
+
+	if removeErr := os.Remove(path); removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+		return removeErr
+	}
+	return nil
+}
+
+func (f *FilesystemBlobStore) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || key[0] == '.' {
+		return "", fmt.Errorf("%w: %q", types.ErrInvalidBlobKey, key)
+	}
+	return filepath.Join(f.directory, key), nil
+}
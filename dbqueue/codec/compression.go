@@ -0,0 +1,77 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+	"sync"
+)
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+)
+
+func Compress(compression types.Compression, payload []byte) ([]byte, error) {
+	switch compression {
+	case types.CompressionNone:
+		return payload, nil
+	case types.CompressionGzip:
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, writeErr := writer.Write(payload); writeErr != nil {
+			return nil, writeErr
+		}
+		if closeErr := writer.Close(); closeErr != nil {
+			return nil, closeErr
+		}
+		return buffer.Bytes(), nil
+	case types.CompressionZstd:
+		encoder, encoderErr := zstdEncoder()
+		if encoderErr != nil {
+			return nil, encoderErr
+		}
+		return encoder.EncodeAll(payload, nil), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", types.ErrCompressionNotSupported, compression)
+	}
+}
+
+// Decompress restores a payload compressed by Compress. It stops with types.ErrPayloadTooLarge once the output
+// exceeds limit bytes, so a corrupt or hostile row cannot expand without bound.
+func Decompress(compression types.Compression, payload []byte, limit int) ([]byte, error) {
+	var reader io.Reader
+	switch compression {
+	case types.CompressionNone:
+		return payload, nil
+	case types.CompressionGzip:
+		gzipReader, readerErr := gzip.NewReader(bytes.NewReader(payload))
+		if readerErr != nil {
+			return nil, readerErr
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case types.CompressionZstd:
+		zstdReader, readerErr := zstd.NewReader(bytes.NewReader(payload), zstd.WithDecoderConcurrency(1))
+		if readerErr != nil {
+			return nil, readerErr
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	default:
+		return nil, fmt.Errorf("%w: %s", types.ErrCompressionNotSupported, compression)
+	}
+
+	decompressed, readErr := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if readErr != nil {
+		return nil, readErr
+	}
+	if len(decompressed) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", types.ErrPayloadTooLarge, limit)
+	}
+	return decompressed, nil
+}
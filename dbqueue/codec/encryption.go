@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+)
+
+const dataKeySize = 32
+
+type StaticKeyProvider struct {
+	currentKeyID string
+	keys         map[string][]byte
+}
+
+func NewStaticKeyProvider(currentKeyID string, keys map[string][]byte) *StaticKeyProvider {
+	return &StaticKeyProvider{
+		currentKeyID: currentKeyID,
+		keys:         keys,
+	}
+}
+
+func (s *StaticKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	key, keyErr := s.Key(ctx, s.currentKeyID)
+	return s.currentKeyID, key, keyErr
+}
+
+func (s *StaticKeyProvider) Key(_ context.Context, id string) ([]byte, error) {
+	key, exists := s.keys[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", types.ErrEncryptionKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// Encrypt seals the payload with a fresh data key and stores that data key sealed with the given key in front of it,
+// so rotating the key never requires touching the payload encryption itself.
+func Encrypt(key []byte, payload []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, readErr := rand.Read(dataKey); readErr != nil {
+		return nil, readErr
+	}
+
+	sealedKey, sealKeyErr := seal(key, dataKey)
+	if sealKeyErr != nil {
+		return nil, sealKeyErr
+	}
+
+	sealedPayload, sealPayloadErr := seal(dataKey, payload)
+	if sealPayloadErr != nil {
+		return nil, sealPayloadErr
+	}
+
+	envelope := binary.AppendUvarint(nil, uint64(len(sealedKey)))
+	envelope = append(envelope, sealedKey...)
+	return append(envelope, sealedPayload...), nil
+}
+
+func Decrypt(key []byte, envelope []byte) ([]byte, error) {
+	sealedKeyLength, read := binary.Uvarint(envelope)
+	if read <= 0 || uint64(len(envelope)-read) < sealedKeyLength {
+		return nil, types.ErrMalformedEncryptedPayload
+	}
+	sealedKey := envelope[read : read+int(sealedKeyLength)]
+	sealedPayload := envelope[read+int(sealedKeyLength):]
+
+	dataKey, openKeyErr := open(key, sealedKey)
+	if openKeyErr != nil {
+		return nil, openKeyErr
+	}
+
+	return open(dataKey, sealedPayload)
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	aead, aeadErr := newAEAD(key)
+	if aeadErr != nil {
+		return nil, aeadErr
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, readErr := rand.Read(nonce); readErr != nil {
+		return nil, readErr
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	aead, aeadErr := newAEAD(key)
+	if aeadErr != nil {
+		return nil, aeadErr
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, types.ErrMalformedEncryptedPayload
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, blockErr := aes.NewCipher(key)
+	if blockErr != nil {
+		return nil, blockErr
+	}
+	return cipher.NewGCM(block)
+}
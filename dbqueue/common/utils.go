@@ -1,5 +1,25 @@
 package common
 
+import "time"
+
 func Ptr[T any](v T) *T {
 	return &v
 }
+
+func First[T any](values []T) T {
+	var zero T
+	if len(values) == 0 {
+		return zero
+	}
+	return values[0]
+}
+
+// VisibleAfter returns the visible_after value that hides a message for at least delay. Messages are visible once
+// visible_after is not after the current second, so the deadline is rounded up to a whole second, and a delay of
+// zero or less makes the message visible right away.
+func VisibleAfter(now time.Time, delay time.Duration) int64 {
+	if delay <= 0 {
+		return now.Add(delay).Unix()
+	}
+	return now.Add(delay + time.Second - time.Nanosecond).Unix()
+}
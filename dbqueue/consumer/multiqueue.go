@@ -0,0 +1,197 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"sync"
+	"time"
+)
+
+type Strategy string
+
+const (
+	StrategyStrictPriority Strategy = "strict"
+	StrategyWeighted       Strategy = "weighted"
+)
+
+type Source struct {
+	Name   string
+	Queue  types.Queue
+	Weight int
+}
+
+type Handler func(ctx context.Context, source Source, message types.ReceivedMessage)
+
+type Options struct {
+	Strategy          *Strategy
+	Workers           *int
+	VisibilityTimeout *time.Duration
+	WaitTime          *time.Duration
+	Metrics           types.Metrics
+	OnDecodeError     func(source Source, err *types.DecodeError)
+}
+
+func (o *Options) Defaults() *Options {
+	if o.Strategy == nil {
+		o.Strategy = common.Ptr(StrategyWeighted)
+	}
+	if o.Workers == nil {
+		o.Workers = common.Ptr(1)
+	}
+	if o.VisibilityTimeout == nil {
+		o.VisibilityTimeout = common.Ptr(30 * time.Second)
+	}
+	if o.WaitTime == nil {
+		o.WaitTime = common.Ptr(1 * time.Second)
+	}
+	if o.Metrics == nil {
+		o.Metrics = types.NopMetrics{}
+	}
+	return o
+}
+
+type MultiQueueConsumer struct {
+	sources []Source
+	current []int
+	options *Options
+}
+
+func NewMultiQueueConsumer(sources []Source, options ...Options) (*MultiQueueConsumer, error) {
+	consumerOptions := common.First(options)
+	consumerOptions.Defaults()
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%w: no sources", types.ErrInvalidConsumer)
+	}
+	if *consumerOptions.Workers < 1 {
+		return nil, fmt.Errorf("%w: workers must be at least one", types.ErrInvalidConsumer)
+	}
+	if *consumerOptions.Strategy != StrategyStrictPriority && *consumerOptions.Strategy != StrategyWeighted {
+		return nil, fmt.Errorf("%w: unknown strategy %q", types.ErrInvalidConsumer, *consumerOptions.Strategy)
+	}
+	for _, source := range sources {
+		if source.Queue == nil || source.Weight < 1 {
+			return nil, fmt.Errorf("%w: source %q needs a queue and a positive weight",
+				types.ErrInvalidConsumer, source.Name)
+		}
+	}
+
+	return &MultiQueueConsumer{
+		sources: sources,
+		current: make([]int, len(sources)),
+		options: &consumerOptions,
+	}, nil
+}
+
+func (p *MultiQueueConsumer) Run(ctx context.Context, handler Handler) error {
+	var workers sync.WaitGroup
+	defer workers.Wait()
+
+	slots := make(chan struct{}, *p.options.Workers)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case slots <- struct{}{}:
+		}
+
+		free := 1
+	reserve:
+		for free < *p.options.Workers {
+			select {
+			case slots <- struct{}{}:
+				free++
+			default:
+				break reserve
+			}
+		}
+
+		source, messages, claimErr := p.claim(ctx, free)
+		if claimErr != nil {
+			for ; free > 0; free-- {
+				<-slots
+			}
+			if errors.Is(claimErr, context.Canceled) || errors.Is(claimErr, context.DeadlineExceeded) {
+				return ctx.Err()
+			}
+			return claimErr
+		}
+
+		for ; free > len(messages); free-- {
+			<-slots
+		}
+
+		for _, message := range messages {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				defer func() { <-slots }()
+
+				started := time.Now()
+				handler(ctx, source, message)
+				p.options.Metrics.HandlerDuration(source.Name, time.Since(started))
+			}()
+		}
+
+		if len(messages) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(*p.options.WaitTime):
+			}
+		}
+	}
+}
+
+func (p *MultiQueueConsumer) claim(ctx context.Context, count int) (Source, []types.ReceivedMessage, error) {
+	for _, index := range p.order() {
+		source := p.sources[index]
+		options := types.ReceiveMessageOptions{
+			MaxNumberOfMessages: common.Ptr(count),
+			VisibilityTimeout:   p.options.VisibilityTimeout,
+		}
+		if p.options.OnDecodeError != nil {
+			options.OnDecodeError = func(err *types.DecodeError) {
+				p.options.OnDecodeError(source, err)
+			}
+		}
+		messages, claimErr := source.Queue.ClaimMessages(ctx, options)
+		if claimErr != nil {
+			return source, nil, claimErr
+		}
+		if len(messages) > 0 {
+			return source, messages, nil
+		}
+	}
+	return Source{}, nil, nil
+}
+
+func (p *MultiQueueConsumer) order() []int {
+	order := make([]int, 0, len(p.sources))
+	if *p.options.Strategy == StrategyStrictPriority {
+		for index := range p.sources {
+			order = append(order, index)
+		}
+		return order
+	}
+
+	total, selected := 0, 0
+	for index, source := range p.sources {
+		p.current[index] += source.Weight
+		total += source.Weight
+		if p.current[index] > p.current[selected] {
+			selected = index
+		}
+	}
+	p.current[selected] -= total
+
+	order = append(order, selected)
+	for index := range p.sources {
+		if index != selected {
+			order = append(order, index)
+		}
+	}
+	return order
+}
@@ -2,18 +2,41 @@ package dbqueue
 
 import (
 	"context"
+	"database/sql"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/yunussandikci/dbqueue-go/dbqueue/engines"
 	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
 )
 
-func OpenPostgreSQL(ctx context.Context, connString string) (types.Engine, error) {
-	return engines.NewPostgreSQLEngine(ctx, connString)
+func OpenPostgreSQL(ctx context.Context, connString string, options ...types.EngineOptions) (types.Engine, error) {
+	return engines.NewPostgreSQLEngine(ctx, connString, options...)
 }
 
-func OpenMySQL(ctx context.Context, connString string) (types.Engine, error) {
-	return engines.NewMySQLEngine(ctx, connString)
+func OpenMySQL(ctx context.Context, connString string, options ...types.EngineOptions) (types.Engine, error) {
+	return engines.NewMySQLEngine(ctx, connString, options...)
 }
 
-func OpenSQLite(ctx context.Context, connString string) (types.Engine, error) {
-	return engines.NewSQLiteEngine(ctx, connString)
+func OpenSQLite(ctx context.Context, connString string, options ...types.EngineOptions) (types.Engine, error) {
+	return engines.NewSQLiteEngine(ctx, connString, options...)
+}
+
+func OpenMemory(options ...types.EngineOptions) types.Engine {
+	return engines.NewMemoryEngine(options...)
+}
+
+func FromPgxPool(ctx context.Context, db *pgxpool.Pool, options ...types.EngineOptions) (types.Engine, error) {
+	return engines.NewPostgreSQLEngineFromPool(ctx, db, options...)
+}
+
+// FromSQLDB supports MySQL and SQLite. The PostgreSQL engine runs on pgx, so share a pgxpool.Pool with FromPgxPool.
+func FromSQLDB(ctx context.Context, db *sql.DB, dialect types.Dialect,
+	options ...types.EngineOptions) (types.Engine, error) {
+	switch dialect {
+	case types.DialectMySQL:
+		return engines.NewMySQLEngineFromDB(ctx, db, options...)
+	case types.DialectSQLite:
+		return engines.NewSQLiteEngineFromDB(ctx, db, options...)
+	default:
+		return nil, types.ErrDatabaseNotSupported
+	}
 }
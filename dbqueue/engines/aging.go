@@ -0,0 +1,86 @@
+package engines
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultClaimOrder = "priority DESC, id ASC"
+
+func agingSeconds(options *types.QueueOptions) (int64, error) {
+	if options.PriorityAging == nil {
+		return 0, nil
+	}
+	if *options.PriorityAging < time.Second {
+		return 0, fmt.Errorf("%w: %s", types.ErrInvalidPriorityAging, *options.PriorityAging)
+	}
+	return int64(*options.PriorityAging / time.Second), nil
+}
+
+func agingExpression(priority string, seconds int64) string {
+	return fmt.Sprintf("(%s * %d - created_at)", priority, seconds)
+}
+
+func agingIndexName(name string, seconds int64) string {
+	return fmt.Sprintf("%s%d", agingIndexPrefix(name), seconds)
+}
+
+func agingIndexPrefix(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return fmt.Sprintf("dbqueue_aging_%s_", hex.EncodeToString(sum[:8]))
+}
+
+// staleAgingIndexes picks the aging indexes left behind by earlier intervals out of a table's indexes.
+func staleAgingIndexes(name string, seconds int64, indexes []string) []string {
+	var stale []string
+	for _, index := range indexes {
+		if strings.HasPrefix(index, agingIndexPrefix(name)) && index != agingIndexName(name, seconds) {
+			stale = append(stale, index)
+		}
+	}
+	return stale
+}
+
+func scanIndexNames(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	var indexes []string
+	for rows.Next() {
+		var index string
+		if scanErr := rows.Scan(&index); scanErr != nil {
+			return nil, scanErr
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, rows.Err()
+}
+
+// claimRank is the value claimOrder sorts by before the id.
+func claimRank(priority uint32, createdAt int64, seconds int64) int64 {
+	if seconds == 0 {
+		return int64(priority)
+	}
+	return int64(priority)*seconds - createdAt
+}
+
+// sortClaimed puts a claimed batch in claim order, since UPDATE ... RETURNING yields rows in table order.
+func sortClaimed(messages []types.ReceivedMessage, seconds int64) {
+	slices.SortFunc(messages, func(a, b types.ReceivedMessage) int {
+		return cmp.Or(cmp.Compare(claimRank(b.Priority, b.CreatedAt, seconds),
+			claimRank(a.Priority, a.CreatedAt, seconds)), cmp.Compare(a.ID, b.ID))
+	})
+}
+
+func claimOrder(priority string, seconds int64) string {
+	if seconds == 0 {
+		return defaultClaimOrder
+	}
+	return agingExpression(priority, seconds) + " DESC, id ASC"
+}
@@ -0,0 +1,30 @@
+package engines
+
+import (
+	"encoding/json"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+)
+
+func encodeAttributes(attributes map[string]string) (*string, error) {
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+
+	encoded, marshalErr := json.Marshal(attributes)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	return common.Ptr(string(encoded)), nil
+}
+
+func decodeAttributes(attributes *string) (map[string]string, error) {
+	if attributes == nil {
+		return nil, nil
+	}
+
+	var decoded map[string]string
+	if unmarshalErr := json.Unmarshal([]byte(*attributes), &decoded); unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	return decoded, nil
+}
@@ -0,0 +1,106 @@
+package engines
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+)
+
+const exportChunkSize = 500
+
+// exportRow is a stored message as it was read from the table, before its payload and
+// attributes are decoded into the portable record.
+type exportRow struct {
+	record     types.ExportRecord
+	encoding   payloadEncoding
+	attributes *string
+}
+
+func exportStream(ctx context.Context, w io.Writer, queueOptions *types.QueueOptions,
+	options *types.ExportOptions, load func(afterID uint, limit int) ([]exportRow, error)) (int, error) {
+	encoder := json.NewEncoder(w)
+	lastID := *options.AfterID
+
+	var exported int
+	for {
+		limit := exportChunkSize
+		if *options.Limit > 0 {
+			limit = min(limit, *options.Limit-exported)
+		}
+		if limit <= 0 {
+			return exported, nil
+		}
+
+		rows, loadErr := load(lastID, limit)
+		if loadErr != nil {
+			return exported, loadErr
+		}
+
+		for _, row := range rows {
+			payload, decodeErr := decodePayload(ctx, queueOptions, row.record.Payload, row.encoding)
+			if decodeErr != nil {
+				return exported, decodeErr
+			}
+
+			attributes, attributesErr := decodeAttributes(row.attributes)
+			if attributesErr != nil {
+				return exported, attributesErr
+			}
+
+			row.record.Version = types.ExportFormatVersion
+			row.record.Payload = payload
+			row.record.Attributes = attributes
+			if encodeErr := encoder.Encode(row.record); encodeErr != nil {
+				return exported, encodeErr
+			}
+			lastID = row.record.ID
+			exported++
+		}
+
+		if len(rows) < limit {
+			return exported, nil
+		}
+	}
+}
+
+func importStream(r io.Reader, options *types.ImportOptions,
+	insert func(records []types.ExportRecord) (int, error)) (int, error) {
+	decoder := json.NewDecoder(r)
+	batchSize := max(*options.BatchSize, 1)
+
+	var imported int
+	for {
+		var records []types.ExportRecord
+		for len(records) < batchSize {
+			var record types.ExportRecord
+			decodeErr := decoder.Decode(&record)
+			if errors.Is(decodeErr, io.EOF) {
+				break
+			}
+			if decodeErr != nil {
+				return imported, decodeErr
+			}
+			if record.Version != types.ExportFormatVersion {
+				return imported, fmt.Errorf("%w: %d", types.ErrExportVersionNotSupported, record.Version)
+			}
+			records = append(records, record)
+		}
+
+		if len(records) == 0 {
+			return imported, nil
+		}
+
+		inserted, insertErr := insert(records)
+		imported += inserted
+		if insertErr != nil {
+			return imported, insertErr
+		}
+
+		if len(records) < batchSize {
+			return imported, nil
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package engines
+
+import (
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"sort"
+	"strings"
+)
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func validateFilter(filter *types.MessageFilter) error {
+	if filter.Empty() {
+		return fmt.Errorf("%w: at least one condition is required, use PurgeQueue to remove every message",
+			types.ErrInvalidMessageFilter)
+	}
+	if *filter.ChunkSize < 1 {
+		return fmt.Errorf("%w: chunk size must be positive", types.ErrInvalidMessageFilter)
+	}
+	return nil
+}
+
+func questionPlaceholder() func() string {
+	return func() string {
+		return "?"
+	}
+}
+
+func numberedPlaceholder(offset int) func() string {
+	return func() string {
+		offset++
+		return fmt.Sprintf("$%d", offset)
+	}
+}
+
+// filterConditions renders the filter as an AND-joined predicate. attributeMatch is a format string
+// receiving the key and value placeholders, since every engine stores attributes as a different JSON type.
+func filterConditions(filter *types.MessageFilter, placeholder func() string,
+	attributeMatch string) (string, []any) {
+	var (
+		conditions []string
+		args       []any
+	)
+	add := func(condition string, values ...any) {
+		conditions = append(conditions, condition)
+		args = append(args, values...)
+	}
+
+	if filter.ID != nil {
+		add("id = "+placeholder(), int64(*filter.ID))
+	}
+	if filter.MinPriority != nil {
+		add("priority >= "+placeholder(), int64(*filter.MinPriority))
+	}
+	if filter.MaxPriority != nil {
+		add("priority <= "+placeholder(), int64(*filter.MaxPriority))
+	}
+	if filter.CreatedAfter != nil {
+		add("created_at >= "+placeholder(), *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		add("created_at < "+placeholder(), *filter.CreatedBefore)
+	}
+	if filter.RetrievalAbove != nil {
+		add("retrieval > "+placeholder(), int64(*filter.RetrievalAbove))
+	}
+	if filter.Retrieval != nil {
+		add("retrieval = "+placeholder(), int64(*filter.Retrieval))
+	}
+	if filter.DeduplicationIDPrefix != nil {
+		add("deduplication_id LIKE "+placeholder()+" ESCAPE '!'",
+			likeEscaper.Replace(*filter.DeduplicationIDPrefix)+"%")
+	}
+
+	keys := make([]string, 0, len(filter.Attributes))
+	for key := range filter.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(fmt.Sprintf(attributeMatch, placeholder(), placeholder()), key, filter.Attributes[key])
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,38 @@
+package engines
+
+import (
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"regexp"
+	"strings"
+)
+
+// queueNamePattern accepts names that are valid unquoted identifiers in every supported database and fit into the
+// shortest identifier limit, so a queue keeps its name when it is moved between engines.
+var queueNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+const reservedPrefix = "dbqueue_"
+
+func validateQueueName(name string) error {
+	if !queueNamePattern.MatchString(name) || strings.HasPrefix(name, reservedPrefix) {
+		return fmt.Errorf("%w: %q", types.ErrInvalidQueueName, name)
+	}
+	return nil
+}
+
+func quoteMySQLIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteSQLiteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quotePostgreSQLLiteral quotes a string literal for statements that cannot take bind parameters, like DO blocks.
+func quotePostgreSQLLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func placeholders(count int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", count), ", ")
+}
@@ -0,0 +1,93 @@
+package engines
+
+import (
+	"context"
+	"errors"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"log/slog"
+	"time"
+)
+
+type instrumentation struct {
+	queue              string
+	metrics            types.Metrics
+	logger             *slog.Logger
+	slowQueryThreshold time.Duration
+}
+
+func newInstrumentation(queue string, options *types.EngineOptions) *instrumentation {
+	return &instrumentation{
+		queue:              queue,
+		metrics:            options.Metrics,
+		logger:             options.Logger.With(slog.String("queue", queue)),
+		slowQueryThreshold: *options.SlowQueryThreshold,
+	}
+}
+
+func (p *instrumentation) claimed(messages []types.ReceivedMessage, duration time.Duration) {
+	p.metrics.ClaimDuration(p.queue, duration)
+
+	if duration > p.slowQueryThreshold {
+		p.logger.Warn("slow claim query", slog.Int("count", len(messages)), slog.Duration("duration", duration))
+	} else if len(messages) > 0 {
+		p.logger.Debug("claimed messages", slog.Int("count", len(messages)), slog.Duration("duration", duration))
+	}
+
+	if len(messages) > 0 {
+		p.metrics.MessagesReceived(p.queue, len(messages))
+	}
+	for _, message := range messages {
+		if message.Retrieval > 1 {
+			p.metrics.MessagesRetried(p.queue, 1)
+			p.logger.Debug("retrying message", slog.Uint64("message_id", uint64(message.ID)),
+				slog.Uint64("retrieval", uint64(message.Retrieval)))
+		}
+		p.metrics.EndToEndLatency(p.queue, time.Since(time.Unix(message.CreatedAt, 0)))
+	}
+}
+
+// undecodable reports a claimed row that could not be decoded instead of failing the whole claim.
+func (p *instrumentation) undecodable(opts *types.ReceiveMessageOptions, message types.ReceivedMessage, err error) {
+	p.logger.Warn("skipped undecodable message", slog.Uint64("message_id", uint64(message.ID)),
+		slog.Uint64("retrieval", uint64(message.Retrieval)), slog.Any("error", err))
+	if opts.OnDecodeError != nil {
+		opts.OnDecodeError(&types.DecodeError{ID: message.ID, Retrieval: message.Retrieval, Err: err})
+	}
+}
+
+func (p *instrumentation) deliver(message types.ReceivedMessage, fun func(message types.ReceivedMessage)) {
+	started := time.Now()
+	fun(message)
+	duration := time.Since(started)
+	p.metrics.HandlerDuration(p.queue, duration)
+	p.logger.Debug("handled message", slog.Uint64("message_id", uint64(message.ID)),
+		slog.Uint64("retrieval", uint64(message.Retrieval)), slog.Duration("duration", duration))
+}
+
+func (p *instrumentation) sent(sent, deduplicated int) {
+	if sent > 0 {
+		p.metrics.MessagesSent(p.queue, sent)
+	}
+	if deduplicated > 0 {
+		p.metrics.MessagesDeduplicated(p.queue, deduplicated)
+		p.logger.Debug("dropped duplicate messages", slog.Int("count", deduplicated))
+	}
+}
+
+func (p *instrumentation) deleted(deleted int) {
+	if deleted > 0 {
+		p.metrics.MessagesDeleted(p.queue, deleted)
+	}
+}
+
+func (p *instrumentation) stats(stats *types.QueueStats) {
+	p.metrics.QueueDepth(p.queue, stats.Depth)
+	p.metrics.OldestMessageAge(p.queue, stats.OldestAge)
+}
+
+func (p *instrumentation) stopped(err error) error {
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		p.logger.Error("receiving messages stopped", slog.Any("error", err))
+	}
+	return err
+}
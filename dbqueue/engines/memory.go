@@ -0,0 +1,777 @@
+package engines
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memorySchemaVersion is reported by Health, memory queues are never migrated.
+const memorySchemaVersion = 1
+
+type memoryEngine struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	version uint64
+	tables  map[string]*memoryTable
+	buckets map[string]bucketState
+	options *types.EngineOptions
+}
+
+type memoryTable struct {
+	lastID           uint
+	rows             map[uint]*memoryRow
+	deduplicationIDs map[string]uint
+}
+
+type memoryRow struct {
+	id              uint
+	deduplicationID string
+	payload         []byte
+	priority        uint32
+	retrieval       uint32
+	visibleAfter    int64
+	createdAt       int64
+	encoding        payloadEncoding
+	attributes      map[string]string
+}
+
+type memoryQueue struct {
+	engine          *memoryEngine
+	name            string
+	options         *types.QueueOptions
+	instrumentation *instrumentation
+	agingSeconds    int64
+}
+
+func NewMemoryEngine(options ...types.EngineOptions) types.Engine {
+	engineOptions := common.First(options)
+	p := &memoryEngine{
+		tables:  map[string]*memoryTable{},
+		buckets: map[string]bucketState{},
+		options: engineOptions.Defaults(),
+	}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (p *memoryEngine) Close() error {
+	return nil
+}
+
+func (p *memoryEngine) Ping(_ context.Context) error {
+	return nil
+}
+
+func (p *memoryEngine) Health(ctx context.Context) (*types.Health, error) {
+	started := time.Now()
+	names, namesErr := p.ListQueues(ctx)
+	if namesErr != nil {
+		return nil, namesErr
+	}
+
+	health := &types.Health{
+		Latency:  time.Since(started),
+		Registry: true,
+	}
+	for _, name := range names {
+		health.Queues = append(health.Queues, types.QueueHealth{
+			Name:    name,
+			Version: memorySchemaVersion,
+		})
+	}
+	return health, nil
+}
+
+func (p *memoryEngine) RateLimiter(_ context.Context, name string, rate float64,
+	burst int) (types.RateLimiter, error) {
+	if validateErr := validateRateLimit(rate, burst); validateErr != nil {
+		return nil, validateErr
+	}
+
+	p.mutex.Lock()
+	if _, exists := p.buckets[name]; !exists {
+		p.buckets[name] = bucketState{tokens: float64(burst), updatedAt: time.Now().UnixMicro()}
+	}
+	p.mutex.Unlock()
+
+	return &storedRateLimiter{
+		rate:  rate,
+		burst: float64(burst),
+		load: func(_ context.Context) (bucketState, error) {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			return p.buckets[name], nil
+		},
+		store: func(_ context.Context, previous, next bucketState) (bool, error) {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			if p.buckets[name].version != previous.version {
+				return false, nil
+			}
+			p.buckets[name] = next
+			return true, nil
+		},
+	}, nil
+}
+
+func (p *memoryEngine) OpenQueue(_ context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return nil, validateErr
+	}
+
+	p.mutex.Lock()
+	_, exists := p.tables[name]
+	p.mutex.Unlock()
+	if !exists {
+		return nil, types.ErrQueueNotFound
+	}
+
+	return p.newQueue(name, options)
+}
+
+func (p *memoryEngine) CreateQueue(_ context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return nil, validateErr
+	}
+
+	queue, queueErr := p.newQueue(name, options)
+	if queueErr != nil {
+		return nil, queueErr
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, exists := p.tables[name]; !exists {
+		p.tables[name] = &memoryTable{
+			rows:             map[uint]*memoryRow{},
+			deduplicationIDs: map[string]uint{},
+		}
+	}
+	return queue, nil
+}
+
+func (p *memoryEngine) newQueue(name string, options []types.QueueOptions) (types.Queue, error) {
+	queueOptions := common.First(options)
+	seconds, agingErr := agingSeconds(&queueOptions)
+	if agingErr != nil {
+		return nil, agingErr
+	}
+
+	return &memoryQueue{
+		engine:          p,
+		name:            name,
+		options:         queueOptions.Defaults(),
+		instrumentation: newInstrumentation(name, p.options),
+		agingSeconds:    seconds,
+	}, nil
+}
+
+func (p *memoryEngine) DeleteQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	p.mutex.Lock()
+	table, exists := p.tables[name]
+	delete(p.tables, name)
+	p.changed()
+	p.mutex.Unlock()
+
+	if !exists {
+		return nil
+	}
+	queueOptions := common.First(options)
+	return discardBlobs(ctx, queueOptions.Defaults(), table.blobKeys())
+}
+
+func (p *memoryEngine) PurgeQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	p.mutex.Lock()
+	table, exists := p.tables[name]
+	if !exists {
+		p.mutex.Unlock()
+		return types.ErrQueueNotFound
+	}
+	blobKeys := table.blobKeys()
+	table.rows = map[uint]*memoryRow{}
+	table.deduplicationIDs = map[string]uint{}
+	p.changed()
+	p.mutex.Unlock()
+
+	queueOptions := common.First(options)
+	return discardBlobs(ctx, queueOptions.Defaults(), blobKeys)
+}
+
+func (p *memoryEngine) MigrateQueue(_ context.Context, name string) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, exists := p.tables[name]; !exists {
+		return types.ErrQueueNotFound
+	}
+	return nil
+}
+
+func (p *memoryEngine) MigrateAll(_ context.Context) error {
+	return nil
+}
+
+func (p *memoryEngine) ListQueues(_ context.Context) ([]string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var names []string
+	for name := range p.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// changed wakes every waiting receiver, it must be called with the mutex held after any write.
+func (p *memoryEngine) changed() {
+	p.version++
+	p.cond.Broadcast()
+}
+
+func (p *memoryTable) blobKeys() []string {
+	var blobKeys []*string
+	for _, row := range p.rows {
+		blobKeys = append(blobKeys, row.encoding.blobKey)
+	}
+	return collectBlobKeys(blobKeys)
+}
+
+func (p *memoryTable) sortedRows(afterID uint, match func(row *memoryRow) bool) []*memoryRow {
+	var rows []*memoryRow
+	for id, row := range p.rows {
+		if id > afterID && (match == nil || match(row)) {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].id < rows[j].id
+	})
+	return rows
+}
+
+func (p *memoryTable) insert(row *memoryRow) bool {
+	if _, exists := p.deduplicationIDs[row.deduplicationID]; exists {
+		return false
+	}
+	p.lastID++
+	row.id = p.lastID
+	p.rows[row.id] = row
+	p.deduplicationIDs[row.deduplicationID] = row.id
+	return true
+}
+
+func (p *memoryTable) remove(id uint) (*memoryRow, bool) {
+	row, exists := p.rows[id]
+	if exists {
+		delete(p.rows, id)
+		delete(p.deduplicationIDs, row.deduplicationID)
+	}
+	return row, exists
+}
+
+func (p *memoryQueue) table() (*memoryTable, error) {
+	table, exists := p.engine.tables[p.name]
+	if !exists {
+		return nil, types.ErrQueueNotFound
+	}
+	return table, nil
+}
+
+func (p *memoryQueue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	return p.instrumentation.stopped(p.receiveMessage(ctx, fun, options))
+}
+
+func (p *memoryQueue) receiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	opts := options.Defaults()
+	for {
+		messages, version, claimErr := p.claim(ctx, opts)
+		if claimErr != nil {
+			return claimErr
+		}
+
+		for _, message := range messages {
+			p.instrumentation.deliver(message, fun)
+		}
+
+		if len(messages) == 0 {
+			if waitErr := p.wait(ctx, version, opts.PollInterval()); waitErr != nil {
+				return waitErr
+			}
+		}
+	}
+}
+
+// wait blocks until the engine changes after version, a message of the queue becomes visible, ctx is done or
+// waitTime passes, whichever comes first.
+func (p *memoryQueue) wait(ctx context.Context, version uint64, waitTime time.Duration) error {
+	p.engine.mutex.Lock()
+	defer p.engine.mutex.Unlock()
+
+	deadline := time.Now().Add(waitTime)
+	if table, tableErr := p.table(); tableErr == nil {
+		now := time.Now().Unix()
+		for _, row := range table.rows {
+			if visibleAt := time.Unix(row.visibleAfter, 0); row.visibleAfter > now && visibleAt.Before(deadline) {
+				deadline = visibleAt
+			}
+		}
+	}
+
+	var expired bool
+	wake := func() {
+		p.engine.mutex.Lock()
+		defer p.engine.mutex.Unlock()
+		expired = true
+		p.engine.cond.Broadcast()
+	}
+	timer := time.AfterFunc(time.Until(deadline), wake)
+	defer timer.Stop()
+	stop := context.AfterFunc(ctx, wake)
+	defer stop()
+
+	for p.engine.version == version && !expired {
+		p.engine.cond.Wait()
+	}
+	return ctx.Err()
+}
+
+func (p *memoryQueue) ClaimMessages(ctx context.Context,
+	options types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	messages, _, claimErr := p.claim(ctx, options.Defaults())
+	return messages, claimErr
+}
+
+func (p *memoryQueue) claim(ctx context.Context,
+	opts *types.ReceiveMessageOptions) ([]types.ReceivedMessage, uint64, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, 0, ctxErr
+	}
+
+	granted, acquireErr := acquireClaim(ctx, opts)
+	if acquireErr != nil {
+		return nil, 0, acquireErr
+	}
+
+	claimStarted := time.Now()
+	p.engine.mutex.Lock()
+	table, tableErr := p.table()
+	if tableErr != nil {
+		p.engine.mutex.Unlock()
+		return nil, 0, tableErr
+	}
+
+	now := time.Now()
+	var candidates []*memoryRow
+	for _, row := range table.rows {
+		if row.visibleAfter <= now.Unix() {
+			candidates = append(candidates, row)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		left, right := p.rank(candidates[i]), p.rank(candidates[j])
+		if left != right {
+			return left > right
+		}
+		return candidates[i].id < candidates[j].id
+	})
+	if granted > 0 && len(candidates) > granted {
+		candidates = candidates[:granted]
+	}
+
+	claimed := make([]memoryRow, 0, len(candidates))
+	for _, row := range candidates {
+		row.retrieval++
+		row.visibleAfter = common.VisibleAfter(now, *opts.VisibilityTimeout)
+		claimed = append(claimed, *row)
+	}
+	if len(claimed) > 0 {
+		p.engine.changed()
+	}
+	version := p.engine.version
+	p.engine.mutex.Unlock()
+
+	var messages []types.ReceivedMessage
+	for _, row := range claimed {
+		payload, decodeErr := decodePayload(ctx, p.options, row.payload, row.encoding)
+		if decodeErr != nil {
+			p.instrumentation.undecodable(opts, row.message(nil), decodeErr)
+			continue
+		}
+		messages = append(messages, row.message(bytes.Clone(payload)))
+	}
+	p.instrumentation.claimed(messages, time.Since(claimStarted))
+
+	if releaseErr := releaseClaim(ctx, opts, granted, len(messages)); releaseErr != nil {
+		return nil, 0, releaseErr
+	}
+	return messages, version, nil
+}
+
+// rank orders claims like the SQL engines, by priority or by the aging expression when aging is enabled.
+func (p *memoryQueue) rank(row *memoryRow) int64 {
+	return claimRank(row.priority, row.createdAt, p.agingSeconds)
+}
+
+func (p *memoryRow) message(payload []byte) types.ReceivedMessage {
+	return types.ReceivedMessage{
+		Message: types.Message{
+			Payload:         payload,
+			Priority:        p.priority,
+			DeduplicationID: common.Ptr(p.deduplicationID),
+			VisibleAfter:    common.Ptr(p.visibleAfter),
+			Attributes:      maps.Clone(p.attributes),
+		},
+		ID:        p.id,
+		Retrieval: p.retrieval,
+		CreatedAt: p.createdAt,
+	}
+}
+
+func (p *memoryQueue) SendMessage(ctx context.Context, message *types.Message) error {
+	return p.SendMessageBatch(ctx, []*types.Message{message})
+}
+
+func (p *memoryQueue) SendMessageBatch(ctx context.Context, messages []*types.Message) error {
+	now := time.Now().Unix()
+	records := make([]types.ExportRecord, 0, len(messages))
+	for _, message := range messages {
+		visibleAfter := now
+		if message.VisibleAfter != nil {
+			visibleAfter = *message.VisibleAfter
+		}
+		records = append(records, types.ExportRecord{
+			Payload:         message.Payload,
+			Priority:        message.Priority,
+			DeduplicationID: message.DeduplicationID,
+			VisibleAfter:    visibleAfter,
+			CreatedAt:       now,
+			Attributes:      message.Attributes,
+		})
+	}
+
+	_, insertErr := p.insert(ctx, records)
+	return insertErr
+}
+
+func (p *memoryQueue) insert(ctx context.Context, records []types.ExportRecord) (int, error) {
+	var blobKeys []*string
+	rows := make([]*memoryRow, 0, len(records))
+	for _, record := range records {
+		deduplicationID := uuid.NewString()
+		if record.DeduplicationID != nil {
+			deduplicationID = *record.DeduplicationID
+		}
+
+		payload, encoding, encodeErr := encodePayload(ctx, p.options, record.Payload)
+		if encodeErr != nil {
+			return 0, errors.Join(encodeErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+		blobKeys = append(blobKeys, encoding.blobKey)
+
+		rows = append(rows, &memoryRow{
+			deduplicationID: deduplicationID,
+			payload:         bytes.Clone(payload),
+			priority:        record.Priority,
+			retrieval:       record.Retrieval,
+			visibleAfter:    record.VisibleAfter,
+			createdAt:       record.CreatedAt,
+			encoding:        encoding,
+			attributes:      maps.Clone(record.Attributes),
+		})
+	}
+
+	p.engine.mutex.Lock()
+	table, tableErr := p.table()
+	if tableErr != nil {
+		p.engine.mutex.Unlock()
+		return 0, errors.Join(tableErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+	}
+
+	var duplicateBlobKeys []*string
+	for _, row := range rows {
+		if !table.insert(row) {
+			duplicateBlobKeys = append(duplicateBlobKeys, row.encoding.blobKey)
+		}
+	}
+	p.engine.changed()
+	p.engine.mutex.Unlock()
+	p.instrumentation.sent(len(rows)-len(duplicateBlobKeys), len(duplicateBlobKeys))
+
+	return len(rows) - len(duplicateBlobKeys), discardBlobs(ctx, p.options, collectBlobKeys(duplicateBlobKeys))
+}
+
+func (p *memoryQueue) DeleteMessage(ctx context.Context, id uint) error {
+	return p.DeleteMessageBatch(ctx, []uint{id})
+}
+
+func (p *memoryQueue) DeleteMessageBatch(ctx context.Context, ids []uint) error {
+	p.engine.mutex.Lock()
+	table, tableErr := p.table()
+	if tableErr != nil {
+		p.engine.mutex.Unlock()
+		return tableErr
+	}
+
+	var blobKeys []*string
+	for _, id := range ids {
+		if row, removed := table.remove(id); removed {
+			blobKeys = append(blobKeys, row.encoding.blobKey)
+		}
+	}
+	p.engine.changed()
+	p.engine.mutex.Unlock()
+	p.instrumentation.deleted(len(blobKeys))
+
+	return discardBlobs(ctx, p.options, collectBlobKeys(blobKeys))
+}
+
+func (p *memoryQueue) ChangeMessageVisibility(ctx context.Context, id uint, visibilityTimeout time.Duration) error {
+	return p.ChangeMessageVisibilityBatch(ctx, []uint{id}, visibilityTimeout)
+}
+
+func (p *memoryQueue) ChangeMessageVisibilityBatch(_ context.Context, ids []uint,
+	visibilityTimeout time.Duration) error {
+	p.engine.mutex.Lock()
+	defer p.engine.mutex.Unlock()
+
+	table, tableErr := p.table()
+	if tableErr != nil {
+		return tableErr
+	}
+
+	visibleAfter := common.VisibleAfter(time.Now(), visibilityTimeout)
+	for _, id := range ids {
+		if row, exists := table.rows[id]; exists {
+			row.visibleAfter = visibleAfter
+		}
+	}
+	p.engine.changed()
+	return nil
+}
+
+func (p *memoryQueue) DeleteWhere(ctx context.Context, filter types.MessageFilter) (int, error) {
+	if validateErr := validateFilter(filter.Defaults()); validateErr != nil {
+		return 0, validateErr
+	}
+
+	p.engine.mutex.Lock()
+	table, tableErr := p.table()
+	if tableErr != nil {
+		p.engine.mutex.Unlock()
+		return 0, tableErr
+	}
+
+	var blobKeys []*string
+	for _, row := range table.sortedRows(0, filterMatcher(&filter)) {
+		table.remove(row.id)
+		blobKeys = append(blobKeys, row.encoding.blobKey)
+	}
+	p.engine.changed()
+	p.engine.mutex.Unlock()
+	p.instrumentation.deleted(len(blobKeys))
+
+	return len(blobKeys), discardBlobs(ctx, p.options, collectBlobKeys(blobKeys))
+}
+
+func (p *memoryQueue) RescheduleWhere(_ context.Context, filter types.MessageFilter,
+	visibilityTimeout time.Duration) (int, error) {
+	if validateErr := validateFilter(filter.Defaults()); validateErr != nil {
+		return 0, validateErr
+	}
+
+	p.engine.mutex.Lock()
+	defer p.engine.mutex.Unlock()
+
+	table, tableErr := p.table()
+	if tableErr != nil {
+		return 0, tableErr
+	}
+
+	rows := table.sortedRows(0, filterMatcher(&filter))
+	visibleAfter := common.VisibleAfter(time.Now(), visibilityTimeout)
+	for _, row := range rows {
+		row.visibleAfter = visibleAfter
+	}
+	p.engine.changed()
+	return len(rows), nil
+}
+
+// filterMatcher evaluates the filter in Go with the same conditions filterConditions renders for the SQL engines.
+func filterMatcher(filter *types.MessageFilter) func(row *memoryRow) bool {
+	return func(row *memoryRow) bool {
+		switch {
+		case filter.ID != nil && row.id != *filter.ID,
+			filter.MinPriority != nil && row.priority < *filter.MinPriority,
+			filter.MaxPriority != nil && row.priority > *filter.MaxPriority,
+			filter.CreatedAfter != nil && row.createdAt < *filter.CreatedAfter,
+			filter.CreatedBefore != nil && row.createdAt >= *filter.CreatedBefore,
+			filter.RetrievalAbove != nil && row.retrieval <= *filter.RetrievalAbove,
+			filter.Retrieval != nil && row.retrieval != *filter.Retrieval,
+			filter.DeduplicationIDPrefix != nil && !strings.HasPrefix(row.deduplicationID,
+				*filter.DeduplicationIDPrefix):
+			return false
+		}
+		for key, value := range filter.Attributes {
+			if attribute, exists := row.attributes[key]; !exists || attribute != value {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (p *memoryQueue) Export(ctx context.Context, w io.Writer, options ...types.ExportOptions) (int, error) {
+	exportOptions := common.First(options)
+	return exportStream(ctx, w, p.options, exportOptions.Defaults(), func(afterID uint, limit int) ([]exportRow, error) {
+		p.engine.mutex.Lock()
+		defer p.engine.mutex.Unlock()
+
+		table, tableErr := p.table()
+		if tableErr != nil {
+			return nil, tableErr
+		}
+
+		rows := table.sortedRows(afterID, nil)
+		if len(rows) > limit {
+			rows = rows[:limit]
+		}
+
+		chunk := make([]exportRow, 0, len(rows))
+		for _, row := range rows {
+			attributes, attributesErr := encodeAttributes(row.attributes)
+			if attributesErr != nil {
+				return nil, attributesErr
+			}
+			chunk = append(chunk, exportRow{
+				record: types.ExportRecord{
+					ID:              row.id,
+					DeduplicationID: common.Ptr(row.deduplicationID),
+					Payload:         bytes.Clone(row.payload),
+					Priority:        row.priority,
+					VisibleAfter:    row.visibleAfter,
+					Retrieval:       row.retrieval,
+					CreatedAt:       row.createdAt,
+				},
+				encoding:   row.encoding,
+				attributes: attributes,
+			})
+		}
+		return chunk, nil
+	})
+}
+
+func (p *memoryQueue) Import(ctx context.Context, r io.Reader, options ...types.ImportOptions) (int, error) {
+	importOptions := common.First(options)
+	return importStream(r, importOptions.Defaults(), func(records []types.ExportRecord) (int, error) {
+		return p.insert(ctx, records)
+	})
+}
+
+func (p *memoryQueue) ReEncrypt(ctx context.Context) (int, error) {
+	if p.options.KeyProvider == nil {
+		return 0, types.ErrKeyProviderNotConfigured
+	}
+
+	currentKeyID, _, keyErr := p.options.KeyProvider.CurrentKey(ctx)
+	if keyErr != nil {
+		return 0, keyErr
+	}
+
+	p.engine.mutex.Lock()
+	table, tableErr := p.table()
+	if tableErr != nil {
+		p.engine.mutex.Unlock()
+		return 0, tableErr
+	}
+	stale := table.sortedRows(0, func(row *memoryRow) bool {
+		return row.encoding.keyID == nil || *row.encoding.keyID != currentKeyID
+	})
+	chunk := make([]memoryRow, 0, len(stale))
+	for _, row := range stale {
+		chunk = append(chunk, *row)
+	}
+	p.engine.mutex.Unlock()
+
+	var rewritten int
+	for _, row := range chunk {
+		reencrypted, reencryptErr := reencryptPayload(ctx, p.options, row.payload, row.encoding)
+		if reencryptErr != nil {
+			return rewritten, reencryptErr
+		}
+
+		p.engine.mutex.Lock()
+		current, applied := table.rows[row.id]
+		applied = applied && current.encoding.keyID == row.encoding.keyID &&
+			current.encoding.blobKey == row.encoding.blobKey
+		if applied {
+			current.payload = reencrypted.payload
+			current.encoding.keyID = &reencrypted.keyID
+			current.encoding.blobKey = reencrypted.blobKey
+			rewritten++
+		}
+		p.engine.mutex.Unlock()
+
+		if finishErr := finishReencrypt(ctx, p.options, row.encoding, reencrypted, applied); finishErr != nil {
+			return rewritten, finishErr
+		}
+	}
+	return rewritten, nil
+}
+
+func (p *memoryQueue) Stats(_ context.Context) (*types.QueueStats, error) {
+	p.engine.mutex.Lock()
+	table, tableErr := p.table()
+	if tableErr != nil {
+		p.engine.mutex.Unlock()
+		return nil, tableErr
+	}
+
+	var (
+		stats    types.QueueStats
+		oldestAt *int64
+	)
+	now := time.Now()
+	for _, row := range table.rows {
+		stats.Depth++
+		if row.visibleAfter <= now.Unix() {
+			stats.Visible++
+		}
+		if oldestAt == nil || row.createdAt < *oldestAt {
+			oldestAt = common.Ptr(row.createdAt)
+		}
+	}
+	p.engine.mutex.Unlock()
+
+	if oldestAt != nil {
+		stats.OldestAge = now.Sub(time.Unix(*oldestAt, 0))
+	}
+
+	p.instrumentation.stats(&stats)
+	return &stats, nil
+}
@@ -0,0 +1,88 @@
+package engines
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+)
+
+const registryTable = "dbqueue_registry"
+
+// migration moves a queue table to version by running statements, formatted with the quoted table name as %[1]s
+// (and the quoted id sequence as %[2]s on PostgreSQL).
+// columns are the columns the migration introduces and are used to detect the version of unregistered tables.
+type migration struct {
+	version    int
+	columns    []string
+	statements []string
+}
+
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func latestSchemaVersion(migrations []migration) int {
+	return migrations[len(migrations)-1].version
+}
+
+func detectSchemaVersion(migrations []migration, columns []string) int {
+	present := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		present[column] = true
+	}
+
+	version := 0
+	for _, m := range migrations {
+		for _, column := range m.columns {
+			if !present[column] {
+				return version
+			}
+		}
+		version = m.version
+	}
+
+	return version
+}
+
+func checkSchemaVersion(migrations []migration, name string, version int) error {
+	switch latest := latestSchemaVersion(migrations); {
+	case version == 0:
+		return fmt.Errorf("%w: %s", types.ErrInvalidQueueSchema, name)
+	case version < latest:
+		return fmt.Errorf("%w: %s is at version %d, expected %d", types.ErrQueueSchemaOutdated, name, version, latest)
+	case version > latest:
+		return fmt.Errorf("%w: %s is at version %d, latest known is %d",
+			types.ErrQueueSchemaVersionNotSupported, name, version, latest)
+	}
+	return nil
+}
+
+func pendingMigrations(migrations []migration, name string, version int) ([]migration, error) {
+	if latest := latestSchemaVersion(migrations); version > latest {
+		return nil, fmt.Errorf("%w: %s is at version %d, latest known is %d",
+			types.ErrQueueSchemaVersionNotSupported, name, version, latest)
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func groupColumns(rows *sql.Rows) (map[string][]string, error) {
+	columns := map[string][]string{}
+	for rows.Next() {
+		var table, column string
+		if scanErr := rows.Scan(&table, &column); scanErr != nil {
+			return nil, scanErr
+		}
+		columns[table] = append(columns[table], column)
+	}
+	return columns, rows.Err()
+}
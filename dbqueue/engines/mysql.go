@@ -5,150 +5,640 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
 	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
-	"strconv"
-	"strings"
+	"io"
+	"math"
+	"slices"
+	"sort"
 	"time"
 )
 
 type mysqlEngine struct {
-	db *sql.DB
+	db      *sql.DB
+	owned   bool
+	options *types.EngineOptions
 }
 
 type mysqlQueue struct {
-	db    *sql.DB
-	table string
+	db              *sql.DB
+	table           string
+	options         *types.QueueOptions
+	instrumentation *instrumentation
+	order           string
 }
 
-func NewMySQLEngine(_ context.Context, conn string) (types.Engine, error) {
+const (
+	mysqlMigrationLock = "dbqueue_migration"
+	mysqlChunkSize     = 1000
+)
+
+var mysqlMigrations = []migration{
+	{
+		version: 1,
+		columns: []string{"id", "deduplication_id", "payload", "priority", "retrieval", "visible_after", "created_at"},
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS %[1]s (
+				id BIGINT AUTO_INCREMENT PRIMARY KEY,
+				deduplication_id VARCHAR(255) UNIQUE,
+				payload BLOB,
+				priority INT DEFAULT 0,
+				retrieval INT DEFAULT 0,
+				visible_after INT(11) NOT NULL,
+				created_at INT(11) NOT NULL);`,
+		},
+	},
+	{
+		version: 2,
+		columns: []string{"compression", "key_id", "blob_key"},
+		statements: []string{
+			`ALTER TABLE %[1]s 
+				MODIFY payload LONGBLOB,
+				ADD COLUMN compression VARCHAR(16),
+				ADD COLUMN key_id VARCHAR(255),
+				ADD COLUMN blob_key VARCHAR(64);`,
+		},
+	},
+	{
+		version:    3,
+		columns:    []string{"attributes"},
+		statements: []string{`ALTER TABLE %[1]s ADD COLUMN attributes JSON;`},
+	},
+}
+
+func NewMySQLEngine(_ context.Context, conn string, options ...types.EngineOptions) (types.Engine, error) {
 	db, newErr := sql.Open("mysql", conn)
 	if newErr != nil {
 		return nil, newErr
 	}
+
+	engineOptions := common.First(options)
+	configureSQLPool(db, engineOptions.Defaults())
 	return &mysqlEngine{
-		db: db,
+		db:      db,
+		owned:   true,
+		options: &engineOptions,
 	}, nil
 }
 
-func (p *mysqlEngine) OpenQueue(ctx context.Context, name string) (types.Queue, error) {
-	var exists int
-	query := `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?;`
-	if queryErr := p.db.QueryRowContext(ctx, query, name).Scan(&exists); queryErr != nil {
-		return nil, queryErr
+func NewMySQLEngineFromDB(_ context.Context, db *sql.DB, options ...types.EngineOptions) (types.Engine, error) {
+	engineOptions := common.First(options)
+	return &mysqlEngine{
+		db:      db,
+		options: engineOptions.Defaults(),
+	}, nil
+}
+
+func (p *mysqlEngine) Close() error {
+	if p.owned {
+		return p.db.Close()
 	}
+	return nil
+}
 
-	if exists == 0 {
-		return nil, types.ErrQueueNotFound
+func (p *mysqlEngine) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *mysqlEngine) Health(ctx context.Context) (*types.Health, error) {
+	started := time.Now()
+	if pingErr := p.Ping(ctx); pingErr != nil {
+		return nil, pingErr
+	}
+	health := &types.Health{
+		Latency: time.Since(started),
 	}
 
-	return &mysqlQueue{
-		db:    p.db,
-		table: name,
+	registry, existsErr := p.tableExists(ctx, p.db, registryTable)
+	if existsErr != nil {
+		return health, existsErr
+	}
+	health.Registry = registry
+
+	names, namesErr := p.queueNames(ctx)
+	if namesErr != nil {
+		return health, namesErr
+	}
+
+	var queueErrs []error
+	for _, name := range names {
+		version, _, versionErr := p.queueVersion(ctx, p.db, name)
+		if versionErr == nil {
+			versionErr = checkSchemaVersion(mysqlMigrations, name, version)
+		}
+		health.Queues = append(health.Queues, types.QueueHealth{
+			Name:    name,
+			Version: version,
+			Err:     versionErr,
+		})
+		queueErrs = append(queueErrs, versionErr)
+	}
+	return health, errors.Join(queueErrs...)
+}
+
+func (p *mysqlEngine) RateLimiter(ctx context.Context, name string, rate float64,
+	burst int) (types.RateLimiter, error) {
+	if validateErr := validateRateLimit(rate, burst); validateErr != nil {
+		return nil, validateErr
+	}
+
+	table := quoteMySQLIdentifier(rateLimitTable)
+	createQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		name VARCHAR(255) PRIMARY KEY,
+		tokens DOUBLE NOT NULL,
+		updated_at BIGINT NOT NULL,
+		version BIGINT NOT NULL);`, table)
+	if _, execErr := p.db.ExecContext(ctx, createQuery); execErr != nil {
+		return nil, execErr
+	}
+
+	insertQuery := fmt.Sprintf(`INSERT IGNORE INTO %s (name, tokens, updated_at, version) 
+		VALUES (?, ?, ?, 0);`, table)
+	if _, execErr := p.db.ExecContext(ctx, insertQuery, name, burst, time.Now().UnixMicro()); execErr != nil {
+		return nil, execErr
+	}
+
+	loadQuery := fmt.Sprintf(`SELECT tokens, updated_at, version FROM %s WHERE name = ?;`, table)
+	storeQuery := fmt.Sprintf(`UPDATE %s SET tokens = ?, updated_at = ?, version = ? 
+		WHERE name = ? AND version = ?;`, table)
+	return &storedRateLimiter{
+		rate:  rate,
+		burst: float64(burst),
+		load: func(ctx context.Context) (bucketState, error) {
+			var state bucketState
+			scanErr := p.db.QueryRowContext(ctx, loadQuery, name).Scan(&state.tokens, &state.updatedAt,
+				&state.version)
+			return state, scanErr
+		},
+		store: func(ctx context.Context, previous, next bucketState) (bool, error) {
+			result, execErr := p.db.ExecContext(ctx, storeQuery, next.tokens, next.updatedAt, next.version,
+				name, previous.version)
+			if execErr != nil {
+				return false, execErr
+			}
+			affected, affectedErr := result.RowsAffected()
+			return affected == 1, affectedErr
+		},
 	}, nil
 }
 
-func (p *mysqlEngine) CreateQueue(ctx context.Context, name string) (types.Queue, error) {
-	query := fmt.Sprintf(
-		`CREATE TABLE IF NOT EXISTS %s (
-				id BIGINT AUTO_INCREMENT PRIMARY KEY,
-				deduplication_id VARCHAR(255) UNIQUE,
-				payload BLOB,
-				priority INT DEFAULT 0,
-				retrieval INT DEFAULT 0,
-				visible_after INT(11) NOT NULL,
-				created_at INT(11) NOT NULL);`, name)
-	_, execErr := p.db.ExecContext(ctx, query)
-	return &mysqlQueue{
-		db:    p.db,
-		table: name,
-	}, execErr
+func (p *mysqlEngine) OpenQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return nil, validateErr
+	}
+
+	version, exists, versionErr := p.queueVersion(ctx, p.db, name)
+	if versionErr != nil {
+		return nil, versionErr
+	}
+
+	if !exists {
+		return nil, types.ErrQueueNotFound
+	}
+
+	if checkErr := checkSchemaVersion(mysqlMigrations, name, version); checkErr != nil {
+		return nil, checkErr
+	}
+
+	return p.newQueue(ctx, name, options)
 }
 
-func (p *mysqlEngine) DeleteQueue(ctx context.Context, name string) error {
-	query := fmt.Sprintf("DROP TABLE IF EXISTS %s;", name)
-	_, execErr := p.db.ExecContext(ctx, query)
-	return execErr
+func (p *mysqlEngine) CreateQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return nil, validateErr
+	}
+
+	if migrateErr := p.migrateQueue(ctx, name, true); migrateErr != nil {
+		return nil, migrateErr
+	}
+
+	return p.newQueue(ctx, name, options)
 }
 
-func (p *mysqlEngine) PurgeQueue(ctx context.Context, name string) error {
-	query := fmt.Sprintf("DELETE FROM %s;", name)
-	_, execErr := p.db.ExecContext(ctx, query)
-	return execErr
+func (p *mysqlEngine) newQueue(ctx context.Context, name string,
+	options []types.QueueOptions) (types.Queue, error) {
+	queueOptions := common.First(options)
+	seconds, agingErr := agingSeconds(&queueOptions)
+	if agingErr != nil {
+		return nil, agingErr
+	}
+
+	if seconds > 0 {
+		if indexErr := p.createAgingIndex(ctx, name, seconds); indexErr != nil {
+			return nil, indexErr
+		}
+	}
+
+	return &mysqlQueue{
+		db:              p.db,
+		table:           quoteMySQLIdentifier(name),
+		options:         queueOptions.Defaults(),
+		instrumentation: newInstrumentation(name, p.options),
+		order:           claimOrder("priority", seconds),
+	}, nil
 }
 
-func (p *mysqlQueue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
-	options types.ReceiveMessageOptions) error {
-	opts := options.Defaults()
-	limit := ""
+func (p *mysqlEngine) createAgingIndex(ctx context.Context, name string, seconds int64) error {
+	index := agingIndexName(name, seconds)
 
-	if *opts.MaxNumberOfMessages != 0 {
-		limit = fmt.Sprintf("LIMIT %d", *opts.MaxNumberOfMessages)
+	var count int
+	if scanErr := p.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM information_schema.statistics 
+		WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?;`, name, index).
+		Scan(&count); scanErr != nil {
+		return scanErr
 	}
 
-	for {
-		transaction, beginErr := p.db.BeginTx(ctx, nil)
-		if beginErr != nil {
-			return beginErr
+	if count == 0 {
+		query := fmt.Sprintf(`CREATE INDEX %s ON %s ((%s) DESC, id ASC);`,
+			quoteMySQLIdentifier(index), quoteMySQLIdentifier(name), agingExpression("priority", seconds))
+		if _, indexErr := p.db.ExecContext(ctx, query); indexErr != nil {
+			var mysqlErr *mysql.MySQLError
+			if !errors.As(indexErr, &mysqlErr) || mysqlErr.Number != 1061 {
+				return indexErr
+			}
 		}
+	}
 
-		query := fmt.Sprintf(`SELECT id, deduplication_id, payload, priority, retrieval, created_at 
-		FROM %s WHERE visible_after < ? ORDER BY priority DESC, id ASC %s FOR UPDATE SKIP LOCKED;`, p.table, limit)
+	rows, queryErr := p.db.QueryContext(ctx, `SELECT DISTINCT index_name FROM information_schema.statistics 
+		WHERE table_schema = DATABASE() AND table_name = ?;`, name)
+	if queryErr != nil {
+		return queryErr
+	}
+	indexes, scanErr := scanIndexNames(rows)
+	if scanErr != nil {
+		return scanErr
+	}
 
-		rows, queryErr := transaction.QueryContext(ctx, query, time.Now().Unix())
-		if queryErr != nil {
-			return errors.Join(queryErr, transaction.Rollback())
+	for _, stale := range staleAgingIndexes(name, seconds, indexes) {
+		query := fmt.Sprintf(`DROP INDEX %s ON %s;`, quoteMySQLIdentifier(stale), quoteMySQLIdentifier(name))
+		if _, dropErr := p.db.ExecContext(ctx, query); dropErr != nil {
+			var mysqlErr *mysql.MySQLError
+			if !errors.As(dropErr, &mysqlErr) || mysqlErr.Number != 1091 {
+				return dropErr
+			}
 		}
+	}
+	return nil
+}
 
-		var ids []string
-		var messages []types.ReceivedMessage
-		var visibleAfter = time.Now().Add(*opts.VisibilityTimeout).Unix()
+func (p *mysqlEngine) DeleteQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
 
-		for rows.Next() {
-			var message types.ReceivedMessage
-			if scanErr := rows.Scan(&message.ID, &message.DeduplicationID, &message.Payload,
-				&message.Priority, &message.Retrieval, &message.CreatedAt); scanErr != nil {
-				return errors.Join(scanErr, transaction.Rollback())
+	queueOptions := common.First(options)
+	if queueOptions.BlobStore != nil {
+		exists, existsErr := p.tableExists(ctx, p.db, name)
+		if existsErr != nil {
+			return existsErr
+		}
+		if exists {
+			if purgeErr := p.PurgeQueue(ctx, name, queueOptions); purgeErr != nil {
+				return purgeErr
 			}
+		}
+	}
+
+	query := fmt.Sprintf("DROP TABLE IF EXISTS %s;", quoteMySQLIdentifier(name))
+	if _, execErr := p.db.ExecContext(ctx, query); execErr != nil {
+		return execErr
+	}
+
+	registered, registeredErr := p.tableExists(ctx, p.db, registryTable)
+	if registeredErr != nil || !registered {
+		return registeredErr
+	}
+
+	registryQuery := fmt.Sprintf("DELETE FROM %s WHERE name = ?;", quoteMySQLIdentifier(registryTable))
+	_, execErr := p.db.ExecContext(ctx, registryQuery, name)
+	return execErr
+}
+
+func (p *mysqlEngine) PurgeQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	exists, existsErr := p.tableExists(ctx, p.db, name)
+	if existsErr != nil {
+		return existsErr
+	}
+	if !exists {
+		return types.ErrQueueNotFound
+	}
+
+	queueOptions := common.First(options)
+	if queueOptions.BlobStore == nil {
+		query := fmt.Sprintf("DELETE FROM %s;", quoteMySQLIdentifier(name))
+		_, execErr := p.db.ExecContext(ctx, query)
+		return execErr
+	}
+
+	transaction, beginErr := p.db.BeginTx(ctx, nil)
+	if beginErr != nil {
+		return beginErr
+	}
+
+	query := fmt.Sprintf("SELECT blob_key FROM %s WHERE blob_key IS NOT NULL FOR UPDATE;", quoteMySQLIdentifier(name))
+	rows, queryErr := transaction.QueryContext(ctx, query)
+	if queryErr != nil {
+		return errors.Join(queryErr, transaction.Rollback())
+	}
 
-			message.VisibleAfter = &visibleAfter
-			message.Retrieval++
-			messages = append(messages, message)
-			ids = append(ids, strconv.Itoa(int(message.ID)))
+	var blobKeys []string
+	for rows.Next() {
+		var blobKey string
+		if scanErr := rows.Scan(&blobKey); scanErr != nil {
+			return errors.Join(scanErr, rows.Close(), transaction.Rollback())
 		}
+		blobKeys = append(blobKeys, blobKey)
+	}
+	if rowsErr := errors.Join(rows.Err(), rows.Close()); rowsErr != nil {
+		return errors.Join(rowsErr, transaction.Rollback())
+	}
+
+	deleteQuery := fmt.Sprintf("DELETE FROM %s;", quoteMySQLIdentifier(name))
+	if _, execErr := transaction.ExecContext(ctx, deleteQuery); execErr != nil {
+		return errors.Join(execErr, transaction.Rollback())
+	}
+
+	if commitErr := transaction.Commit(); commitErr != nil {
+		return commitErr
+	}
+
+	return discardBlobs(ctx, queueOptions.Defaults(), blobKeys)
+}
+
+func (p *mysqlEngine) MigrateQueue(ctx context.Context, name string) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	return p.migrateQueue(ctx, name, false)
+}
+
+func (p *mysqlEngine) ListQueues(ctx context.Context) ([]string, error) {
+	return p.queueNames(ctx)
+}
+
+func (p *mysqlEngine) MigrateAll(ctx context.Context) error {
+	names, namesErr := p.queueNames(ctx)
+	if namesErr != nil {
+		return namesErr
+	}
+
+	var migrateErrs []error
+	for _, name := range names {
+		migrateErrs = append(migrateErrs, p.MigrateQueue(ctx, name))
+	}
+	return errors.Join(migrateErrs...)
+}
+
+func (p *mysqlEngine) migrateQueue(ctx context.Context, name string, create bool) error {
+	conn, connErr := p.db.Conn(ctx)
+	if connErr != nil {
+		return connErr
+	}
+	defer conn.Close()
 
-		if rowsErr := rows.Err(); rowsErr != nil {
-			return errors.Join(rowsErr, transaction.Rollback())
+	var acquired sql.NullInt64
+	lockQuery := `SELECT GET_LOCK(?, 30);`
+	if lockErr := conn.QueryRowContext(ctx, lockQuery, mysqlMigrationLock).Scan(&acquired); lockErr != nil {
+		return lockErr
+	}
+	if acquired.Int64 != 1 {
+		return types.ErrMigrationLockTimeout
+	}
+
+	var released sql.NullInt64
+	unlockQuery := `SELECT RELEASE_LOCK(?);`
+	return errors.Join(p.migrateQueueLocked(ctx, conn, name, create),
+		conn.QueryRowContext(context.WithoutCancel(ctx), unlockQuery, mysqlMigrationLock).Scan(&released))
+}
+
+// migrateQueueLocked records every applied migration on its own, as MySQL commits each DDL statement implicitly.
+func (p *mysqlEngine) migrateQueueLocked(ctx context.Context, conn *sql.Conn, name string, create bool) error {
+	registryQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				name VARCHAR(255) PRIMARY KEY,
+				version INT NOT NULL,
+				updated_at BIGINT NOT NULL);`, quoteMySQLIdentifier(registryTable))
+	if _, execErr := conn.ExecContext(ctx, registryQuery); execErr != nil {
+		return execErr
+	}
+
+	version, exists, versionErr := p.queueVersion(ctx, conn, name)
+	if versionErr != nil {
+		return versionErr
+	}
+
+	if !exists && !create {
+		return types.ErrQueueNotFound
+	}
+
+	if exists && version == 0 {
+		return fmt.Errorf("%w: %s", types.ErrInvalidQueueSchema, name)
+	}
+
+	pending, pendingErr := pendingMigrations(mysqlMigrations, name, version)
+	if pendingErr != nil {
+		return pendingErr
+	}
+
+	upsertQuery := fmt.Sprintf(`INSERT INTO %s (name, version, updated_at) VALUES (?, ?, ?) 
+		ON DUPLICATE KEY UPDATE version = VALUES(version), updated_at = VALUES(updated_at);`, quoteMySQLIdentifier(registryTable))
+	for _, m := range pending {
+		for _, statement := range m.statements {
+			if _, execErr := conn.ExecContext(ctx, fmt.Sprintf(statement, quoteMySQLIdentifier(name))); execErr != nil {
+				return execErr
+			}
 		}
 
-		if closeErr := rows.Close(); closeErr != nil {
-			return errors.Join(closeErr, transaction.Rollback())
+		if _, execErr := conn.ExecContext(ctx, upsertQuery, name, m.version, time.Now().Unix()); execErr != nil {
+			return execErr
 		}
+	}
 
-		if len(messages) != 0 {
-			updateQuery := fmt.Sprintf(`UPDATE %s SET visible_after = ?, retrieval = retrieval + 1 
-		WHERE id IN (%s);`, p.table, strings.Join(ids, ", "))
+	if len(pending) == 0 {
+		_, execErr := conn.ExecContext(ctx, upsertQuery, name, version, time.Now().Unix())
+		return execErr
+	}
 
-			if _, execErr := transaction.ExecContext(ctx, updateQuery, visibleAfter); execErr != nil {
-				return errors.Join(execErr, transaction.Rollback())
-			}
+	return nil
+}
+
+func (p *mysqlEngine) queueVersion(ctx context.Context, querier sqlQuerier, name string) (int, bool, error) {
+	exists, existsErr := p.tableExists(ctx, querier, name)
+	if existsErr != nil || !exists {
+		return 0, false, existsErr
+	}
+
+	registered, registeredErr := p.tableExists(ctx, querier, registryTable)
+	if registeredErr != nil {
+		return 0, true, registeredErr
+	}
+
+	if registered {
+		var version int
+		query := fmt.Sprintf("SELECT version FROM %s WHERE name = ?;", quoteMySQLIdentifier(registryTable))
+		scanErr := querier.QueryRowContext(ctx, query, name).Scan(&version)
+		if scanErr == nil {
+			return version, true, nil
 		}
+		if !errors.Is(scanErr, sql.ErrNoRows) {
+			return 0, true, scanErr
+		}
+	}
 
-		if commitErr := transaction.Commit(); commitErr != nil {
-			return commitErr
+	query := `SELECT table_name, column_name FROM information_schema.columns 
+		WHERE table_schema = DATABASE() AND table_name = ?;`
+	rows, queryErr := querier.QueryContext(ctx, query, name)
+	if queryErr != nil {
+		return 0, true, queryErr
+	}
+	defer rows.Close()
+
+	columns, groupErr := groupColumns(rows)
+	return detectSchemaVersion(mysqlMigrations, columns[name]), true, groupErr
+}
+
+func (p *mysqlEngine) queueNames(ctx context.Context) ([]string, error) {
+	query := `SELECT table_name, column_name FROM information_schema.columns 
+		WHERE table_schema = DATABASE() AND table_name <> ?;`
+	rows, queryErr := p.db.QueryContext(ctx, query, registryTable)
+	if queryErr != nil {
+		return nil, queryErr
+	}
+	defer rows.Close()
+
+	columns, groupErr := groupColumns(rows)
+	if groupErr != nil {
+		return nil, groupErr
+	}
+
+	var names []string
+	for table, tableColumns := range columns {
+		if detectSchemaVersion(mysqlMigrations, tableColumns) != 0 {
+			names = append(names, table)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (p *mysqlEngine) tableExists(ctx context.Context, querier sqlQuerier, name string) (bool, error) {
+	var exists int
+	query := `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?;`
+	queryErr := querier.QueryRowContext(ctx, query, name).Scan(&exists)
+	return exists != 0, queryErr
+}
+
+func (p *mysqlQueue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	return p.instrumentation.stopped(p.receiveMessage(ctx, fun, options))
+}
+
+func (p *mysqlQueue) receiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	opts := options.Defaults()
+	for {
+		messages, claimErr := p.claimMessages(ctx, opts)
+		if claimErr != nil {
+			return claimErr
 		}
 
 		for _, message := range messages {
-			fun(message)
+			p.instrumentation.deliver(message, fun)
 		}
 
 		if len(messages) == 0 {
-			time.Sleep(*opts.WaitTime)
+			time.Sleep(opts.PollInterval())
 		}
 	}
 }
 
+func (p *mysqlQueue) ClaimMessages(ctx context.Context,
+	options types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	return p.claimMessages(ctx, options.Defaults())
+}
+
+func (p *mysqlQueue) claimMessages(ctx context.Context,
+	opts *types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	query := fmt.Sprintf(`SELECT id, deduplication_id, payload, priority, retrieval, created_at,
+			compression, key_id, blob_key, attributes 
+		FROM %s WHERE visible_after <= ? ORDER BY %s LIMIT ? FOR UPDATE SKIP LOCKED;`, p.table, p.order)
+
+	granted, acquireErr := acquireClaim(ctx, opts)
+	if acquireErr != nil {
+		return nil, acquireErr
+	}
+	limit := int64(granted)
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	transaction, beginErr := p.db.BeginTx(ctx, nil)
+	if beginErr != nil {
+		return nil, beginErr
+	}
+
+	claimStarted := time.Now()
+	rows, queryErr := transaction.QueryContext(ctx, query, time.Now().Unix(), limit)
+	if queryErr != nil {
+		return nil, errors.Join(queryErr, transaction.Rollback())
+	}
+
+	var messages []types.ReceivedMessage
+	var visibleAfter = common.VisibleAfter(time.Now(), *opts.VisibilityTimeout)
+	var args = []any{visibleAfter}
+
+	for rows.Next() {
+		var (
+			message       types.ReceivedMessage
+			encoding      payloadEncoding
+			rawAttributes *string
+		)
+		if scanErr := rows.Scan(&message.ID, &message.DeduplicationID, &message.Payload,
+			&message.Priority, &message.Retrieval, &message.CreatedAt, &encoding.compression,
+			&encoding.keyID, &encoding.blobKey, &rawAttributes); scanErr != nil {
+			return nil, errors.Join(scanErr, rows.Close(), transaction.Rollback())
+		}
+
+		message.VisibleAfter = &visibleAfter
+		message.Retrieval++
+		args = append(args, message.ID)
+
+		if decodeErr := decodeMessage(ctx, p.options, &message, encoding, rawAttributes); decodeErr != nil {
+			p.instrumentation.undecodable(opts, message, decodeErr)
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	if rowsErr := errors.Join(rows.Err(), rows.Close()); rowsErr != nil {
+		return nil, errors.Join(rowsErr, transaction.Rollback())
+	}
+
+	updateQuery := fmt.Sprintf(`UPDATE %s SET visible_after = ?, retrieval = retrieval + 1 
+		WHERE id IN (%%s);`, p.table)
+	if execErr := execInChunks(ctx, transaction, updateQuery, args[:1], args[1:]); execErr != nil {
+		return nil, errors.Join(execErr, transaction.Rollback())
+	}
+
+	if commitErr := transaction.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+	p.instrumentation.claimed(messages, time.Since(claimStarted))
+
+	if releaseErr := releaseClaim(ctx, opts, granted, len(messages)); releaseErr != nil {
+		return nil, releaseErr
+	}
+	return messages, nil
+}
+
 func (p *mysqlQueue) SendMessage(ctx context.Context, message *types.Message) error {
 	return p.SendMessageBatch(ctx, []*types.Message{message})
 }
@@ -161,14 +651,15 @@ func (p *mysqlQueue) SendMessageBatch(ctx context.Context, messages []*types.Mes
 	}
 
 	query := fmt.Sprintf(`INSERT IGNORE INTO %s 
-		(deduplication_id, payload, priority, visible_after, created_at) 
-		VALUES (?, ?, ?, ?, ?);`, p.table)
+		(deduplication_id, payload, priority, visible_after, created_at, compression, key_id, blob_key, attributes) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`, p.table)
 
 	statement, prepareErr := transaction.Prepare(query)
 	if prepareErr != nil {
 		return errors.Join(prepareErr, transaction.Rollback())
 	}
 
+	var blobKeys, duplicateBlobKeys []*string
 	for _, message := range messages {
 		var (
 			deduplicationID string
@@ -186,14 +677,37 @@ func (p *mysqlQueue) SendMessageBatch(ctx context.Context, messages []*types.Mes
 			visibleAfter = now.Unix()
 		}
 
-		_, execErr := statement.Exec(deduplicationID, message.Payload,
-			message.Priority, visibleAfter, now.Unix())
+		attributes, attributesErr := encodeAttributes(message.Attributes)
+		if attributesErr != nil {
+			return errors.Join(attributesErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		payload, encoding, encodeErr := encodePayload(ctx, p.options, message.Payload)
+		if encodeErr != nil {
+			return errors.Join(encodeErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+		blobKeys = append(blobKeys, encoding.blobKey)
+
+		result, execErr := statement.Exec(deduplicationID, payload, message.Priority, visibleAfter, now.Unix(),
+			encoding.compression, encoding.keyID, encoding.blobKey, attributes)
 		if execErr != nil {
-			return errors.Join(execErr, transaction.Rollback())
+			return errors.Join(execErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		if affected, affectedErr := result.RowsAffected(); affectedErr == nil && affected == 0 {
+			duplicateBlobKeys = append(duplicateBlobKeys, encoding.blobKey)
 		}
 	}
 
-	return errors.Join(transaction.Commit(), statement.Close())
+	if commitErr := errors.Join(transaction.Commit(), statement.Close()); commitErr != nil {
+		return errors.Join(commitErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+	}
+	p.instrumentation.sent(len(messages)-len(duplicateBlobKeys), len(duplicateBlobKeys))
+
+	return discardBlobs(ctx, p.options, collectBlobKeys(duplicateBlobKeys))
 }
 
 func (p *mysqlQueue) DeleteMessage(ctx context.Context, id uint) error {
@@ -208,13 +722,29 @@ func (p *mysqlQueue) DeleteMessageBatch(ctx context.Context, ids []uint) error {
 	}
 	defer statement.Close()
 
+	blobQuery := fmt.Sprintf(`SELECT blob_key FROM %s WHERE id = ?;`, p.table)
+	var blobKeys []*string
 	for _, id := range ids {
-		if _, execErr := statement.Exec(id); execErr != nil {
+		var blobKey *string
+		if p.options.BlobStore != nil {
+			scanErr := p.db.QueryRowContext(ctx, blobQuery, id).Scan(&blobKey)
+			if scanErr != nil && !errors.Is(scanErr, sql.ErrNoRows) {
+				return scanErr
+			}
+		}
+
+		result, execErr := statement.Exec(id)
+		if execErr != nil {
 			return execErr
 		}
+
+		if affected, affectedErr := result.RowsAffected(); affectedErr == nil && affected != 0 {
+			blobKeys = append(blobKeys, blobKey)
+		}
 	}
+	p.instrumentation.deleted(len(blobKeys))
 
-	return nil
+	return discardBlobs(ctx, p.options, collectBlobKeys(blobKeys))
 }
 
 func (p *mysqlQueue) ChangeMessageVisibility(ctx context.Context, id uint, visibilityTimeout time.Duration) error {
@@ -230,7 +760,7 @@ func (p *mysqlQueue) ChangeMessageVisibilityBatch(ctx context.Context, ids []uin
 	}
 	defer statement.Close()
 
-	visibleAfter := time.Now().Add(visibilityTimeout).Unix()
+	visibleAfter := common.VisibleAfter(time.Now(), visibilityTimeout)
 	for _, id := range ids {
 		if _, execErr := statement.Exec(visibleAfter, id); execErr != nil {
 			return execErr
@@ -239,3 +769,284 @@ func (p *mysqlQueue) ChangeMessageVisibilityBatch(ctx context.Context, ids []uin
 
 	return nil
 }
+
+func (p *mysqlQueue) DeleteWhere(ctx context.Context, filter types.MessageFilter) (int, error) {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id IN (%%s);`, p.table)
+	return p.filterChunks(ctx, &filter, func(transaction *sql.Tx, ids []any) error {
+		return execInChunks(ctx, transaction, query, nil, ids)
+	}, func(blobKeys []*string) error {
+		p.instrumentation.deleted(len(blobKeys))
+		return discardBlobs(ctx, p.options, collectBlobKeys(blobKeys))
+	})
+}
+
+func (p *mysqlQueue) RescheduleWhere(ctx context.Context, filter types.MessageFilter,
+	visibilityTimeout time.Duration) (int, error) {
+	visibleAfter := common.VisibleAfter(time.Now(), visibilityTimeout)
+
+	query := fmt.Sprintf(`UPDATE %s SET visible_after = ? WHERE id IN (%%s);`, p.table)
+	return p.filterChunks(ctx, &filter, func(transaction *sql.Tx, ids []any) error {
+		return execInChunks(ctx, transaction, query, []any{visibleAfter}, ids)
+	}, nil)
+}
+
+// execInChunks runs query once per chunk of ids, filling its %s with the placeholders of the chunk, so long id lists
+// stay below the 65,535 placeholders MySQL allows in a prepared statement.
+func execInChunks(ctx context.Context, transaction *sql.Tx, query string, leading []any, ids []any) error {
+	for chunk := range slices.Chunk(ids, mysqlChunkSize) {
+		chunkArgs := append(slices.Clone(leading), chunk...)
+		if _, execErr := transaction.ExecContext(ctx, fmt.Sprintf(query, placeholders(len(chunk))),
+			chunkArgs...); execErr != nil {
+			return execErr
+		}
+	}
+	return nil
+}
+
+func (p *mysqlQueue) filterChunks(ctx context.Context, filter *types.MessageFilter,
+	apply func(transaction *sql.Tx, ids []any) error,
+	fun func(blobKeys []*string) error) (int, error) {
+	if validateErr := validateFilter(filter.Defaults()); validateErr != nil {
+		return 0, validateErr
+	}
+
+	conditions, args := filterConditions(filter, questionPlaceholder(),
+		`JSON_CONTAINS(attributes, JSON_OBJECT(%s, %s))`)
+	query := fmt.Sprintf(`SELECT id, blob_key FROM %s WHERE id > ? AND %s ORDER BY id LIMIT ? FOR UPDATE;`,
+		p.table, conditions)
+
+	var (
+		lastID   uint
+		affected int
+	)
+	for {
+		transaction, beginErr := p.db.BeginTx(ctx, nil)
+		if beginErr != nil {
+			return affected, beginErr
+		}
+
+		chunkArgs := append(append([]any{lastID}, args...), *filter.ChunkSize)
+		rows, queryErr := transaction.QueryContext(ctx, query, chunkArgs...)
+		if queryErr != nil {
+			return affected, errors.Join(queryErr, transaction.Rollback())
+		}
+
+		var (
+			ids      []any
+			blobKeys []*string
+		)
+		for rows.Next() {
+			var blobKey *string
+			if scanErr := rows.Scan(&lastID, &blobKey); scanErr != nil {
+				return affected, errors.Join(scanErr, rows.Close(), transaction.Rollback())
+			}
+			ids = append(ids, lastID)
+			blobKeys = append(blobKeys, blobKey)
+		}
+		if rowsErr := errors.Join(rows.Err(), rows.Close()); rowsErr != nil {
+			return affected, errors.Join(rowsErr, transaction.Rollback())
+		}
+
+		if len(ids) != 0 {
+			if applyErr := apply(transaction, ids); applyErr != nil {
+				return affected, errors.Join(applyErr, transaction.Rollback())
+			}
+		}
+
+		if commitErr := transaction.Commit(); commitErr != nil {
+			return affected, commitErr
+		}
+		affected += len(ids)
+
+		if fun != nil {
+			if funErr := fun(blobKeys); funErr != nil {
+				return affected, funErr
+			}
+		}
+
+		if len(ids) < *filter.ChunkSize {
+			return affected, nil
+		}
+	}
+}
+
+func (p *mysqlQueue) Export(ctx context.Context, w io.Writer, options ...types.ExportOptions) (int, error) {
+	query := fmt.Sprintf(`SELECT id, deduplication_id, payload, priority, visible_after, retrieval, created_at, 
+		compression, key_id, blob_key, attributes FROM %s WHERE id > ? ORDER BY id LIMIT ?;`, p.table)
+
+	exportOptions := common.First(options)
+	return exportStream(ctx, w, p.options, exportOptions.Defaults(), func(afterID uint, limit int) ([]exportRow, error) {
+		rows, queryErr := p.db.QueryContext(ctx, query, afterID, limit)
+		if queryErr != nil {
+			return nil, queryErr
+		}
+
+		var chunk []exportRow
+		for rows.Next() {
+			var row exportRow
+			if scanErr := rows.Scan(&row.record.ID, &row.record.DeduplicationID, &row.record.Payload,
+				&row.record.Priority, &row.record.VisibleAfter, &row.record.Retrieval, &row.record.CreatedAt,
+				&row.encoding.compression, &row.encoding.keyID, &row.encoding.blobKey,
+				&row.attributes); scanErr != nil {
+				return nil, errors.Join(scanErr, rows.Close())
+			}
+			chunk = append(chunk, row)
+		}
+
+		return chunk, errors.Join(rows.Err(), rows.Close())
+	})
+}
+
+func (p *mysqlQueue) Import(ctx context.Context, r io.Reader, options ...types.ImportOptions) (int, error) {
+	importOptions := common.First(options)
+	return importStream(r, importOptions.Defaults(), func(records []types.ExportRecord) (int, error) {
+		return p.importBatch(ctx, records)
+	})
+}
+
+func (p *mysqlQueue) importBatch(ctx context.Context, records []types.ExportRecord) (int, error) {
+	transaction, beginTransactionErr := p.db.BeginTx(ctx, nil)
+	if beginTransactionErr != nil {
+		return 0, beginTransactionErr
+	}
+
+	query := fmt.Sprintf(`INSERT IGNORE INTO %s 
+		(deduplication_id, payload, priority, visible_after, retrieval, created_at, compression, key_id, blob_key, 
+		attributes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`, p.table)
+
+	statement, prepareErr := transaction.Prepare(query)
+	if prepareErr != nil {
+		return 0, errors.Join(prepareErr, transaction.Rollback())
+	}
+
+	var blobKeys, duplicateBlobKeys []*string
+	for _, record := range records {
+		var deduplicationID string
+		if record.DeduplicationID == nil {
+			deduplicationID = uuid.NewString()
+		} else {
+			deduplicationID = *record.DeduplicationID
+		}
+
+		attributes, attributesErr := encodeAttributes(record.Attributes)
+		if attributesErr != nil {
+			return 0, errors.Join(attributesErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		payload, encoding, encodeErr := encodePayload(ctx, p.options, record.Payload)
+		if encodeErr != nil {
+			return 0, errors.Join(encodeErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+		blobKeys = append(blobKeys, encoding.blobKey)
+
+		result, execErr := statement.Exec(deduplicationID, payload, record.Priority, record.VisibleAfter,
+			record.Retrieval, record.CreatedAt, encoding.compression, encoding.keyID, encoding.blobKey, attributes)
+		if execErr != nil {
+			return 0, errors.Join(execErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		if affected, affectedErr := result.RowsAffected(); affectedErr == nil && affected == 0 {
+			duplicateBlobKeys = append(duplicateBlobKeys, encoding.blobKey)
+		}
+	}
+
+	if commitErr := errors.Join(transaction.Commit(), statement.Close()); commitErr != nil {
+		return 0, errors.Join(commitErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+	}
+	p.instrumentation.sent(len(records)-len(duplicateBlobKeys), len(duplicateBlobKeys))
+
+	return len(records) - len(duplicateBlobKeys), discardBlobs(ctx, p.options, collectBlobKeys(duplicateBlobKeys))
+}
+
+func (p *mysqlQueue) ReEncrypt(ctx context.Context) (int, error) {
+	if p.options.KeyProvider == nil {
+		return 0, types.ErrKeyProviderNotConfigured
+	}
+
+	currentKeyID, _, keyErr := p.options.KeyProvider.CurrentKey(ctx)
+	if keyErr != nil {
+		return 0, keyErr
+	}
+
+	selectQuery := fmt.Sprintf(`SELECT id, payload, key_id, blob_key FROM %s 
+		WHERE id > ? AND NOT (key_id <=> ?) ORDER BY id LIMIT ?;`, p.table)
+	updateQuery := fmt.Sprintf(`UPDATE %s SET payload = ?, key_id = ?, blob_key = ? 
+		WHERE id = ? AND key_id <=> ? AND blob_key <=> ?;`, p.table)
+
+	var (
+		lastID    uint
+		rewritten int
+	)
+	for {
+		rows, queryErr := p.db.QueryContext(ctx, selectQuery, lastID, currentKeyID, reencryptChunkSize)
+		if queryErr != nil {
+			return rewritten, queryErr
+		}
+
+		type row struct {
+			id       uint
+			payload  []byte
+			encoding payloadEncoding
+		}
+		var chunk []row
+		for rows.Next() {
+			var r row
+			if scanErr := rows.Scan(&r.id, &r.payload, &r.encoding.keyID, &r.encoding.blobKey); scanErr != nil {
+				return rewritten, errors.Join(scanErr, rows.Close())
+			}
+			chunk = append(chunk, r)
+		}
+		if rowsErr := errors.Join(rows.Err(), rows.Close()); rowsErr != nil {
+			return rewritten, rowsErr
+		}
+
+		for _, r := range chunk {
+			reencrypted, reencryptErr := reencryptPayload(ctx, p.options, r.payload, r.encoding)
+			if reencryptErr != nil {
+				return rewritten, reencryptErr
+			}
+
+			result, execErr := p.db.ExecContext(ctx, updateQuery, reencrypted.payload, reencrypted.keyID,
+				reencrypted.blobKey, r.id, r.encoding.keyID, r.encoding.blobKey)
+			if execErr != nil {
+				return rewritten, errors.Join(execErr, finishReencrypt(ctx, p.options, r.encoding, reencrypted, false))
+			}
+			affected, affectedErr := result.RowsAffected()
+			if affectedErr != nil {
+				return rewritten, affectedErr
+			}
+			if finishErr := finishReencrypt(ctx, p.options, r.encoding, reencrypted, affected == 1); finishErr != nil {
+				return rewritten, finishErr
+			}
+			rewritten += int(affected)
+			lastID = r.id
+		}
+
+		if len(chunk) < reencryptChunkSize {
+			return rewritten, nil
+		}
+	}
+}
+
+func (p *mysqlQueue) Stats(ctx context.Context) (*types.QueueStats, error) {
+	query := fmt.Sprintf(`SELECT COUNT(*), COALESCE(SUM(visible_after <= ?), 0), MIN(created_at) FROM %s;`, p.table)
+
+	var (
+		stats    types.QueueStats
+		oldestAt *int64
+	)
+	now := time.Now()
+	if scanErr := p.db.QueryRowContext(ctx, query, now.Unix()).Scan(&stats.Depth, &stats.Visible,
+		&oldestAt); scanErr != nil {
+		return nil, scanErr
+	}
+	if oldestAt != nil {
+		stats.OldestAge = now.Sub(time.Unix(*oldestAt, 0))
+	}
+
+	p.instrumentation.stats(&stats)
+	return &stats, nil
+}
@@ -0,0 +1,212 @@
+package engines
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/codec"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+)
+
+const reencryptChunkSize = 100
+
+// payloadEncoding is the per-row marker describing how a stored payload has to be
+// transformed back before it is handed to the receiver.
+type payloadEncoding struct {
+	compression *string
+	keyID       *string
+	blobKey     *string
+}
+
+func encodePayload(ctx context.Context, options *types.QueueOptions,
+	payload []byte) ([]byte, payloadEncoding, error) {
+	var encoding payloadEncoding
+
+	if *options.Compression != types.CompressionNone && len(payload) > *options.CompressionThreshold {
+		compressed, compressErr := codec.Compress(*options.Compression, payload)
+		if compressErr != nil {
+			return nil, encoding, compressErr
+		}
+		if len(compressed) < len(payload) {
+			payload = compressed
+			encoding.compression = (*string)(options.Compression)
+		}
+	}
+
+	if options.KeyProvider != nil {
+		encrypted, keyID, encryptErr := encryptPayload(ctx, options.KeyProvider, payload)
+		if encryptErr != nil {
+			return nil, encoding, encryptErr
+		}
+		payload = encrypted
+		encoding.keyID = &keyID
+	}
+
+	if options.BlobStore != nil && len(payload) > *options.BlobThreshold {
+		blobKey := uuid.NewString()
+		if putErr := options.BlobStore.Put(ctx, blobKey, payload); putErr != nil {
+			return nil, encoding, putErr
+		}
+		payload = nil
+		encoding.blobKey = &blobKey
+	}
+
+	return payload, encoding, nil
+}
+
+func decodePayload(ctx context.Context, options *types.QueueOptions, payload []byte,
+	encoding payloadEncoding) ([]byte, error) {
+	payload, loadErr := loadPayload(ctx, options, payload, encoding)
+	if loadErr != nil {
+		return nil, loadErr
+	}
+
+	if encoding.keyID != nil {
+		decrypted, decryptErr := decryptPayload(ctx, options.KeyProvider, payload, *encoding.keyID)
+		if decryptErr != nil {
+			return nil, decryptErr
+		}
+		payload = decrypted
+	}
+
+	if encoding.compression != nil {
+		decompressed, decompressErr := codec.Decompress(types.Compression(*encoding.compression), payload,
+			*options.DecompressionLimit)
+		if decompressErr != nil {
+			return nil, decompressErr
+		}
+		payload = decompressed
+	}
+
+	return payload, nil
+}
+
+// decodeMessage restores the attributes and the payload of a claimed row in place.
+func decodeMessage(ctx context.Context, options *types.QueueOptions, message *types.ReceivedMessage,
+	encoding payloadEncoding, rawAttributes *string) error {
+	attributes, attributesErr := decodeAttributes(rawAttributes)
+	if attributesErr != nil {
+		return attributesErr
+	}
+
+	payload, decodeErr := decodePayload(ctx, options, message.Payload, encoding)
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	message.Attributes = attributes
+	message.Payload = payload
+	return nil
+}
+
+// reencryptedPayload is a payload sealed under the current key. An offloaded payload is written under a fresh blob
+// key, so the row keeps pointing at a readable blob until one update switches its blob_key and key_id together.
+type reencryptedPayload struct {
+	payload []byte
+	keyID   string
+	blobKey *string
+}
+
+func reencryptPayload(ctx context.Context, options *types.QueueOptions, payload []byte,
+	encoding payloadEncoding) (reencryptedPayload, error) {
+	payload, loadErr := loadPayload(ctx, options, payload, encoding)
+	if loadErr != nil {
+		return reencryptedPayload{}, loadErr
+	}
+
+	if encoding.keyID != nil {
+		decrypted, decryptErr := decryptPayload(ctx, options.KeyProvider, payload, *encoding.keyID)
+		if decryptErr != nil {
+			return reencryptedPayload{}, decryptErr
+		}
+		payload = decrypted
+	}
+
+	encrypted, keyID, encryptErr := encryptPayload(ctx, options.KeyProvider, payload)
+	if encryptErr != nil {
+		return reencryptedPayload{}, encryptErr
+	}
+
+	if encoding.blobKey != nil {
+		blobKey := uuid.NewString()
+		if putErr := options.BlobStore.Put(ctx, blobKey, encrypted); putErr != nil {
+			return reencryptedPayload{}, putErr
+		}
+		return reencryptedPayload{keyID: keyID, blobKey: &blobKey}, nil
+	}
+
+	return reencryptedPayload{payload: encrypted, keyID: keyID}, nil
+}
+
+// finishReencrypt discards the blob the row no longer points at, the old one when the update applied and the new
+// one when it did not.
+func finishReencrypt(ctx context.Context, options *types.QueueOptions, encoding payloadEncoding,
+	reencrypted reencryptedPayload, applied bool) error {
+	if applied {
+		return discardBlobs(ctx, options, collectBlobKeys([]*string{encoding.blobKey}))
+	}
+	return discardBlobs(ctx, options, collectBlobKeys([]*string{reencrypted.blobKey}))
+}
+
+func loadPayload(ctx context.Context, options *types.QueueOptions, payload []byte,
+	encoding payloadEncoding) ([]byte, error) {
+	if encoding.blobKey == nil {
+		return payload, nil
+	}
+
+	if options.BlobStore == nil {
+		return nil, types.ErrBlobStoreNotConfigured
+	}
+
+	return options.BlobStore.Get(ctx, *encoding.blobKey)
+}
+
+func discardBlobs(ctx context.Context, options *types.QueueOptions, blobKeys []string) error {
+	if options.BlobStore == nil {
+		return nil
+	}
+
+	var deleteErrs []error
+	for _, blobKey := range blobKeys {
+		deleteErrs = append(deleteErrs, options.BlobStore.Delete(ctx, blobKey))
+	}
+
+	return errors.Join(deleteErrs...)
+}
+
+func encryptPayload(ctx context.Context, provider types.KeyProvider, payload []byte) ([]byte, string, error) {
+	if provider == nil {
+		return nil, "", types.ErrKeyProviderNotConfigured
+	}
+
+	keyID, key, keyErr := provider.CurrentKey(ctx)
+	if keyErr != nil {
+		return nil, "", keyErr
+	}
+
+	encrypted, encryptErr := codec.Encrypt(key, payload)
+	return encrypted, keyID, encryptErr
+}
+
+func decryptPayload(ctx context.Context, provider types.KeyProvider, payload []byte, keyID string) ([]byte, error) {
+	if provider == nil {
+		return nil, types.ErrKeyProviderNotConfigured
+	}
+
+	key, keyErr := provider.Key(ctx, keyID)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+
+	return codec.Decrypt(key, payload)
+}
+
+func collectBlobKeys(blobKeys []*string) []string {
+	var collected []string
+	for _, blobKey := range blobKeys {
+		if blobKey != nil {
+			collected = append(collected, *blobKey)
+		}
+	}
+	return collected
+}
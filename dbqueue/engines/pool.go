@@ -0,0 +1,41 @@
+package engines
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+)
+
+func configureSQLPool(db *sql.DB, options *types.EngineOptions) {
+	if options.MaxOpenConns != nil {
+		db.SetMaxOpenConns(*options.MaxOpenConns)
+	}
+	if options.MaxIdleConns != nil {
+		db.SetMaxIdleConns(*options.MaxIdleConns)
+	}
+	if options.ConnMaxLifetime != nil {
+		db.SetConnMaxLifetime(*options.ConnMaxLifetime)
+	}
+	if options.ConnMaxIdleTime != nil {
+		db.SetConnMaxIdleTime(*options.ConnMaxIdleTime)
+	}
+}
+
+// configurePgxPool rejects MaxIdleConns, since pgx has no cap on idle connections. Idle ones are closed after
+// ConnMaxIdleTime instead.
+func configurePgxPool(config *pgxpool.Config, options *types.EngineOptions) error {
+	if options.MaxIdleConns != nil {
+		return fmt.Errorf("%w: MaxIdleConns", types.ErrPoolOptionNotSupported)
+	}
+	if options.MaxOpenConns != nil {
+		config.MaxConns = int32(*options.MaxOpenConns)
+	}
+	if options.ConnMaxLifetime != nil {
+		config.MaxConnLifetime = *options.ConnMaxLifetime
+	}
+	if options.ConnMaxIdleTime != nil {
+		config.MaxConnIdleTime = *options.ConnMaxIdleTime
+	}
+	return nil
+}
@@ -2,127 +2,676 @@ package engines
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
 	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
-	"strconv"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 )
 
 type postgreSQLEngine struct {
-	db *pgxpool.Pool
+	db      *pgxpool.Pool
+	schema  string
+	owned   bool
+	options *types.EngineOptions
 }
 type postgreSQLQueue struct {
-	db    *pgxpool.Pool
-	table string
+	db              *pgxpool.Pool
+	table           string
+	options         *types.QueueOptions
+	instrumentation *instrumentation
+	order           string
 }
 
-func NewPostgreSQLEngine(ctx context.Context, conn string) (types.Engine, error) {
-	db, newErr := pgxpool.New(ctx, conn)
+type postgreSQLQuerier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+var postgreSQLMigrations = []migration{
+	{
+		version: 1,
+		columns: []string{"id", "deduplication_id", "payload", "priority", "retrieval", "visible_after", "created_at"},
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS %[1]s (
+				id SERIAL PRIMARY KEY,
+				deduplication_id TEXT UNIQUE,
+				payload BYTEA,
+				priority INTEGER DEFAULT 0,
+				retrieval INTEGER DEFAULT 0,
+				visible_after BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()),
+				created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()));`,
+		},
+	},
+	{
+		version: 2,
+		columns: []string{"compression", "key_id", "blob_key"},
+		statements: []string{
+			`ALTER TABLE %[1]s 
+				ALTER COLUMN id TYPE BIGINT,
+				ADD COLUMN compression TEXT,
+				ADD COLUMN key_id TEXT,
+				ADD COLUMN blob_key TEXT;`,
+			`DO $$
+			DECLARE
+				id_sequence TEXT := pg_get_serial_sequence(%[2]s, 'id');
+			BEGIN
+				IF id_sequence IS NOT NULL THEN
+					EXECUTE 'ALTER SEQUENCE ' || id_sequence || ' AS BIGINT';
+				END IF;
+			END $$;`,
+		},
+	},
+	{
+		version:    3,
+		columns:    []string{"attributes"},
+		statements: []string{`ALTER TABLE %[1]s ADD COLUMN attributes JSONB;`},
+	},
+}
+
+func NewPostgreSQLEngine(ctx context.Context, conn string, options ...types.EngineOptions) (types.Engine, error) {
+	engineOptions := common.First(options)
+	engineOptions.Defaults()
+
+	config, parseErr := pgxpool.ParseConfig(conn)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if configureErr := configurePgxPool(config, &engineOptions); configureErr != nil {
+		return nil, configureErr
+	}
+
+	db, newErr := pgxpool.NewWithConfig(ctx, config)
 	if newErr != nil {
 		return nil, newErr
 	}
 	return &postgreSQLEngine{
-		db: db,
+		db:      db,
+		schema:  *engineOptions.Schema,
+		owned:   true,
+		options: &engineOptions,
 	}, nil
 }
 
-func (p *postgreSQLEngine) OpenQueue(ctx context.Context, name string) (types.Queue, error) {
-	var (
-		exists = false
-		query  = `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = $1);`
-	)
-	if queryErr := p.db.QueryRow(ctx, query, name).Scan(&exists); queryErr != nil {
-		return nil, queryErr
+func NewPostgreSQLEngineFromPool(_ context.Context, db *pgxpool.Pool,
+	options ...types.EngineOptions) (types.Engine, error) {
+	engineOptions := common.First(options)
+	engineOptions.Defaults()
+	return &postgreSQLEngine{
+		db:      db,
+		schema:  *engineOptions.Schema,
+		options: &engineOptions,
+	}, nil
+}
+
+func (p *postgreSQLEngine) Close() error {
+	if p.owned {
+		p.db.Close()
+	}
+	return nil
+}
+
+func (p *postgreSQLEngine) Ping(ctx context.Context) error {
+	return p.db.Ping(ctx)
+}
+
+func (p *postgreSQLEngine) Health(ctx context.Context) (*types.Health, error) {
+	started := time.Now()
+	if pingErr := p.Ping(ctx); pingErr != nil {
+		return nil, pingErr
+	}
+	health := &types.Health{
+		Latency: time.Since(started),
+	}
+
+	registry, existsErr := p.tableExists(ctx, p.db, registryTable)
+	if existsErr != nil {
+		return health, existsErr
+	}
+	health.Registry = registry
+
+	names, namesErr := p.queueNames(ctx)
+	if namesErr != nil {
+		return health, namesErr
+	}
+
+	var queueErrs []error
+	for _, name := range names {
+		version, _, versionErr := p.queueVersion(ctx, p.db, name)
+		if versionErr == nil {
+			versionErr = checkSchemaVersion(postgreSQLMigrations, name, version)
+		}
+		health.Queues = append(health.Queues, types.QueueHealth{
+			Name:    name,
+			Version: version,
+			Err:     versionErr,
+		})
+		queueErrs = append(queueErrs, versionErr)
+	}
+	return health, errors.Join(queueErrs...)
+}
+
+func (p *postgreSQLEngine) RateLimiter(ctx context.Context, name string, rate float64,
+	burst int) (types.RateLimiter, error) {
+	if validateErr := validateRateLimit(rate, burst); validateErr != nil {
+		return nil, validateErr
+	}
+
+	transaction, beginErr := p.db.Begin(ctx)
+	if beginErr != nil {
+		return nil, beginErr
+	}
+
+	if _, lockErr := transaction.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1));`,
+		p.identifier(registryTable)); lockErr != nil {
+		return nil, errors.Join(lockErr, transaction.Rollback(ctx))
+	}
+
+	table := p.identifier(rateLimitTable)
+	statements := []string{
+		fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;`, pgx.Identifier{p.schema}.Sanitize()),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			name TEXT PRIMARY KEY,
+			tokens DOUBLE PRECISION NOT NULL,
+			updated_at BIGINT NOT NULL,
+			version BIGINT NOT NULL);`, table),
+	}
+	for _, statement := range statements {
+		if _, execErr := transaction.Exec(ctx, statement); execErr != nil {
+			return nil, errors.Join(execErr, transaction.Rollback(ctx))
+		}
+	}
+
+	insertQuery := fmt.Sprintf(`INSERT INTO %s (name, tokens, updated_at, version) VALUES ($1, $2, $3, 0) 
+		ON CONFLICT (name) DO NOTHING;`, table)
+	if _, execErr := transaction.Exec(ctx, insertQuery, name, burst, time.Now().UnixMicro()); execErr != nil {
+		return nil, errors.Join(execErr, transaction.Rollback(ctx))
+	}
+
+	if commitErr := transaction.Commit(ctx); commitErr != nil {
+		return nil, commitErr
+	}
+
+	loadQuery := fmt.Sprintf(`SELECT tokens, updated_at, version FROM %s WHERE name = $1;`, table)
+	storeQuery := fmt.Sprintf(`UPDATE %s SET tokens = $1, updated_at = $2, version = $3 
+		WHERE name = $4 AND version = $5;`, table)
+	return &storedRateLimiter{
+		rate:  rate,
+		burst: float64(burst),
+		load: func(ctx context.Context) (bucketState, error) {
+			var state bucketState
+			scanErr := p.db.QueryRow(ctx, loadQuery, name).Scan(&state.tokens, &state.updatedAt, &state.version)
+			return state, scanErr
+		},
+		store: func(ctx context.Context, previous, next bucketState) (bool, error) {
+			tag, execErr := p.db.Exec(ctx, storeQuery, next.tokens, next.updatedAt, next.version,
+				name, previous.version)
+			if execErr != nil {
+				return false, execErr
+			}
+			return tag.RowsAffected() == 1, nil
+		},
+	}, nil
+}
+
+func (p *postgreSQLEngine) OpenQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return nil, validateErr
+	}
+
+	version, exists, versionErr := p.queueVersion(ctx, p.db, name)
+	if versionErr != nil {
+		return nil, versionErr
 	}
 
 	if !exists {
 		return nil, types.ErrQueueNotFound
 	}
 
+	if checkErr := checkSchemaVersion(postgreSQLMigrations, name, version); checkErr != nil {
+		return nil, checkErr
+	}
+
+	return p.newQueue(ctx, name, options)
+}
+
+func (p *postgreSQLEngine) CreateQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return nil, validateErr
+	}
+
+	if migrateErr := p.migrateQueue(ctx, name, true); migrateErr != nil {
+		return nil, migrateErr
+	}
+
+	return p.newQueue(ctx, name, options)
+}
+
+func (p *postgreSQLEngine) newQueue(ctx context.Context, name string,
+	options []types.QueueOptions) (types.Queue, error) {
+	queueOptions := common.First(options)
+	seconds, agingErr := agingSeconds(&queueOptions)
+	if agingErr != nil {
+		return nil, agingErr
+	}
+
+	if seconds > 0 {
+		if indexErr := p.createAgingIndex(ctx, name, seconds); indexErr != nil {
+			return nil, indexErr
+		}
+	}
+
 	return &postgreSQLQueue{
-		db:    p.db,
-		table: name,
+		db:              p.db,
+		table:           p.identifier(name),
+		options:         queueOptions.Defaults(),
+		instrumentation: newInstrumentation(name, p.options),
+		order:           claimOrder("priority::BIGINT", seconds),
 	}, nil
 }
 
-func (p *postgreSQLEngine) CreateQueue(ctx context.Context, name string) (types.Queue, error) {
-	query := fmt.Sprintf(
-		`CREATE TABLE IF NOT EXISTS %s (
-				id SERIAL PRIMARY KEY,
-				deduplication_id TEXT UNIQUE,
-				payload BYTEA,
-				priority INTEGER DEFAULT 0,
-				retrieval INTEGER DEFAULT 0,
-				visible_after BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()),
-				created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()));`, name)
-	_, execErr := p.db.Exec(ctx, query)
-	return &postgreSQLQueue{
-		db:    p.db,
-		table: name,
-	}, execErr
+// createAgingIndex builds the index concurrently, outside any transaction, so claims and sends are not blocked
+// while it is built. An invalid index left by an interrupted build is dropped and built again.
+func (p *postgreSQLEngine) createAgingIndex(ctx context.Context, name string, seconds int64) error {
+	index := agingIndexName(name, seconds)
+	query := `SELECT c.relname, i.indisvalid FROM pg_index AS i 
+		JOIN pg_class AS c ON c.oid = i.indexrelid 
+		JOIN pg_class AS t ON t.oid = i.indrelid 
+		JOIN pg_namespace AS n ON n.oid = t.relnamespace 
+		WHERE n.nspname = $1 AND t.relname = $2;`
+	rows, queryErr := p.db.Query(ctx, query, p.schema, name)
+	if queryErr != nil {
+		return queryErr
+	}
+
+	valid := map[string]bool{}
+	for rows.Next() {
+		var (
+			indexName  string
+			indexValid bool
+		)
+		if scanErr := rows.Scan(&indexName, &indexValid); scanErr != nil {
+			rows.Close()
+			return scanErr
+		}
+		valid[indexName] = indexValid
+	}
+	rows.Close()
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return rowsErr
+	}
+
+	if indexValid, exists := valid[index]; exists && !indexValid {
+		dropQuery := fmt.Sprintf(`DROP INDEX CONCURRENTLY IF EXISTS %s;`, pgx.Identifier{p.schema, index}.Sanitize())
+		if _, dropErr := p.db.Exec(ctx, dropQuery); dropErr != nil {
+			return dropErr
+		}
+	}
+
+	createQuery := fmt.Sprintf(`CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s (%s DESC, id ASC);`,
+		pgx.Identifier{index}.Sanitize(), p.identifier(name), agingExpression("priority::BIGINT", seconds))
+	if _, indexErr := p.db.Exec(ctx, createQuery); indexErr != nil {
+		return indexErr
+	}
+
+	for _, stale := range staleAgingIndexes(name, seconds, slices.Collect(maps.Keys(valid))) {
+		dropQuery := fmt.Sprintf(`DROP INDEX CONCURRENTLY IF EXISTS %s;`, pgx.Identifier{p.schema, stale}.Sanitize())
+		if _, dropErr := p.db.Exec(ctx, dropQuery); dropErr != nil {
+			return dropErr
+		}
+	}
+	return nil
 }
 
-func (p *postgreSQLEngine) DeleteQueue(ctx context.Context, name string) error {
-	query := fmt.Sprintf("DROP TABLE IF EXISTS %s;", name)
-	_, execErr := p.db.Exec(ctx, query)
+func (p *postgreSQLEngine) DeleteQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	queueOptions := common.First(options)
+	if queueOptions.BlobStore != nil {
+		exists, existsErr := p.tableExists(ctx, p.db, name)
+		if existsErr != nil {
+			return existsErr
+		}
+		if exists {
+			if purgeErr := p.PurgeQueue(ctx, name, queueOptions); purgeErr != nil {
+				return purgeErr
+			}
+		}
+	}
+
+	query := fmt.Sprintf("DROP TABLE IF EXISTS %s;", p.identifier(name))
+	if _, execErr := p.db.Exec(ctx, query); execErr != nil {
+		return execErr
+	}
+
+	registered, registeredErr := p.tableExists(ctx, p.db, registryTable)
+	if registeredErr != nil || !registered {
+		return registeredErr
+	}
+
+	registryQuery := fmt.Sprintf("DELETE FROM %s WHERE name = $1;", p.identifier(registryTable))
+	_, execErr := p.db.Exec(ctx, registryQuery, name)
 	return execErr
 }
 
-func (p *postgreSQLEngine) PurgeQueue(ctx context.Context, name string) error {
-	query := fmt.Sprintf("DELETE FROM %s;", name)
-	_, execErr := p.db.Exec(ctx, query, name)
-	return execErr
+func (p *postgreSQLEngine) PurgeQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	exists, existsErr := p.tableExists(ctx, p.db, name)
+	if existsErr != nil {
+		return existsErr
+	}
+	if !exists {
+		return types.ErrQueueNotFound
+	}
+
+	queueOptions := common.First(options)
+	if queueOptions.BlobStore == nil {
+		query := fmt.Sprintf("DELETE FROM %s;", p.identifier(name))
+		_, execErr := p.db.Exec(ctx, query)
+		return execErr
+	}
+
+	query := fmt.Sprintf(`WITH deleted AS (DELETE FROM %s RETURNING blob_key) 
+		SELECT blob_key FROM deleted WHERE blob_key IS NOT NULL;`, p.identifier(name))
+	rows, queryErr := p.db.Query(ctx, query)
+	if queryErr != nil {
+		return queryErr
+	}
+
+	blobKeys, collectErr := pgx.CollectRows(rows, pgx.RowTo[string])
+	if collectErr != nil {
+		return collectErr
+	}
+
+	return discardBlobs(ctx, queueOptions.Defaults(), blobKeys)
 }
 
-func (p *postgreSQLQueue) ReceiveMessage(ctx context.Context,
-	fun func(message types.ReceivedMessage), options types.ReceiveMessageOptions) error {
-	opts := options.Defaults()
-	limit := strconv.Itoa(*opts.MaxNumberOfMessages)
-	if *opts.MaxNumberOfMessages == 0 {
-		limit = "ALL"
+func (p *postgreSQLEngine) MigrateQueue(ctx context.Context, name string) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	return p.migrateQueue(ctx, name, false)
+}
+
+func (p *postgreSQLEngine) ListQueues(ctx context.Context) ([]string, error) {
+	return p.queueNames(ctx)
+}
+
+func (p *postgreSQLEngine) MigrateAll(ctx context.Context) error {
+	names, namesErr := p.queueNames(ctx)
+	if namesErr != nil {
+		return namesErr
+	}
+
+	var migrateErrs []error
+	for _, name := range names {
+		migrateErrs = append(migrateErrs, p.MigrateQueue(ctx, name))
+	}
+	return errors.Join(migrateErrs...)
+}
+
+func (p *postgreSQLEngine) migrateQueue(ctx context.Context, name string, create bool) error {
+	transaction, beginErr := p.db.Begin(ctx)
+	if beginErr != nil {
+		return beginErr
+	}
+
+	if _, lockErr := transaction.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1));`,
+		p.identifier(registryTable)); lockErr != nil {
+		return errors.Join(lockErr, transaction.Rollback(ctx))
+	}
+
+	schemaQuery := fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;`, pgx.Identifier{p.schema}.Sanitize())
+	if _, execErr := transaction.Exec(ctx, schemaQuery); execErr != nil {
+		return errors.Join(execErr, transaction.Rollback(ctx))
+	}
+
+	registryQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				name TEXT PRIMARY KEY,
+				version INTEGER NOT NULL,
+				updated_at BIGINT NOT NULL);`, p.identifier(registryTable))
+	if _, execErr := transaction.Exec(ctx, registryQuery); execErr != nil {
+		return errors.Join(execErr, transaction.Rollback(ctx))
+	}
+
+	version, exists, versionErr := p.queueVersion(ctx, transaction, name)
+	if versionErr != nil {
+		return errors.Join(versionErr, transaction.Rollback(ctx))
+	}
+
+	if !exists && !create {
+		return errors.Join(types.ErrQueueNotFound, transaction.Rollback(ctx))
+	}
+
+	if exists && version == 0 {
+		return errors.Join(fmt.Errorf("%w: %s", types.ErrInvalidQueueSchema, name), transaction.Rollback(ctx))
+	}
+
+	pending, pendingErr := pendingMigrations(postgreSQLMigrations, name, version)
+	if pendingErr != nil {
+		return errors.Join(pendingErr, transaction.Rollback(ctx))
+	}
+
+	for _, m := range pending {
+		for _, statement := range m.statements {
+			if _, execErr := transaction.Exec(ctx, fmt.Sprintf(statement, p.identifier(name),
+				quotePostgreSQLLiteral(p.identifier(name)))); execErr != nil {
+				return errors.Join(execErr, transaction.Rollback(ctx))
+			}
+		}
+	}
+
+	upsertQuery := fmt.Sprintf(`INSERT INTO %s (name, version, updated_at) VALUES ($1, $2, $3) 
+		ON CONFLICT (name) DO UPDATE SET version = EXCLUDED.version, updated_at = EXCLUDED.updated_at;`, p.identifier(registryTable))
+	if _, execErr := transaction.Exec(ctx, upsertQuery, name, latestSchemaVersion(postgreSQLMigrations),
+		time.Now().Unix()); execErr != nil {
+		return errors.Join(execErr, transaction.Rollback(ctx))
+	}
+
+	return transaction.Commit(ctx)
+}
+
+func (p *postgreSQLEngine) queueVersion(ctx context.Context, querier postgreSQLQuerier,
+	name string) (int, bool, error) {
+	exists, existsErr := p.tableExists(ctx, querier, name)
+	if existsErr != nil || !exists {
+		return 0, false, existsErr
+	}
+
+	registered, registeredErr := p.tableExists(ctx, querier, registryTable)
+	if registeredErr != nil {
+		return 0, true, registeredErr
 	}
 
+	if registered {
+		var version int
+		query := fmt.Sprintf("SELECT version FROM %s WHERE name = $1;", p.identifier(registryTable))
+		scanErr := querier.QueryRow(ctx, query, name).Scan(&version)
+		if scanErr == nil {
+			return version, true, nil
+		}
+		if !errors.Is(scanErr, pgx.ErrNoRows) {
+			return 0, true, scanErr
+		}
+	}
+
+	query := `SELECT column_name FROM information_schema.columns 
+		WHERE table_schema = $1 AND table_name = $2;`
+	rows, queryErr := querier.Query(ctx, query, p.schema, name)
+	if queryErr != nil {
+		return 0, true, queryErr
+	}
+
+	columns, collectErr := pgx.CollectRows(rows, pgx.RowTo[string])
+	return detectSchemaVersion(postgreSQLMigrations, columns), true, collectErr
+}
+
+func (p *postgreSQLEngine) queueNames(ctx context.Context) ([]string, error) {
+	query := `SELECT table_name, column_name FROM information_schema.columns 
+		WHERE table_schema = $1 AND table_name <> $2;`
+	rows, queryErr := p.db.Query(ctx, query, p.schema, registryTable)
+	if queryErr != nil {
+		return nil, queryErr
+	}
+
+	columns := map[string][]string{}
+	var table, column string
+	if _, forEachErr := pgx.ForEachRow(rows, []any{&table, &column}, func() error {
+		columns[table] = append(columns[table], column)
+		return nil
+	}); forEachErr != nil {
+		return nil, forEachErr
+	}
+
+	var names []string
+	for table, tableColumns := range columns {
+		if detectSchemaVersion(postgreSQLMigrations, tableColumns) != 0 {
+			names = append(names, table)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (p *postgreSQLEngine) tableExists(ctx context.Context, querier postgreSQLQuerier, name string) (bool, error) {
+	var (
+		exists = false
+		query  = `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = $1 AND table_name = $2);`
+	)
+	queryErr := querier.QueryRow(ctx, query, p.schema, name).Scan(&exists)
+	return exists, queryErr
+}
+
+func (p *postgreSQLEngine) identifier(name string) string {
+	return pgx.Identifier{p.schema, name}.Sanitize()
+}
+
+// validatePostgreSQLText rejects NUL characters up front, since PostgreSQL refuses them in TEXT and JSONB values.
+func validatePostgreSQLText(deduplicationID string, attributes map[string]string) error {
+	if strings.ContainsRune(deduplicationID, 0) {
+		return fmt.Errorf("%w: deduplication id %q", types.ErrNULNotSupported, deduplicationID)
+	}
+	for key, value := range attributes {
+		if strings.ContainsRune(key, 0) || strings.ContainsRune(value, 0) {
+			return fmt.Errorf("%w: attribute %q", types.ErrNULNotSupported, key)
+		}
+	}
+	return nil
+}
+
+func (p *postgreSQLQueue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	return p.instrumentation.stopped(p.receiveMessage(ctx, fun, options))
+}
+
+func (p *postgreSQLQueue) receiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	opts := options.Defaults()
 	for {
-		query := fmt.Sprintf(`UPDATE %s 
-		SET retrieval = retrieval + 1, visible_after = %d
-		WHERE id IN (
-			SELECT id FROM %s 
-			WHERE visible_after < %d
-			ORDER BY priority DESC, id ASC 
-			FOR UPDATE SKIP LOCKED
-			LIMIT %s
-		)
-		RETURNING id, deduplication_id, payload, priority, visible_after, retrieval, created_at;`,
-			p.table, time.Now().Add(*opts.VisibilityTimeout).Unix(), p.table, time.Now().Unix(), limit)
+		messages, claimErr := p.claimMessages(ctx, opts)
+		if claimErr != nil {
+			return claimErr
+		}
 
-		rows, queryErr := p.db.Query(ctx, query)
-		if queryErr != nil {
-			return queryErr
+		for _, message := range messages {
+			p.instrumentation.deliver(message, fun)
 		}
 
-		rowCount := 0
-		for rows.Next() {
-			rowCount += 1
-			var msg types.ReceivedMessage
-			if scanErr := rows.Scan(&msg.ID, &msg.DeduplicationID, &msg.Payload, &msg.Priority, &msg.VisibleAfter,
-				&msg.Retrieval, &msg.CreatedAt); scanErr != nil {
-				return scanErr
-			}
-			fun(msg)
+		if len(messages) == 0 {
+			time.Sleep(opts.PollInterval())
 		}
+	}
+}
 
-		if rowsErr := rows.Err(); rowsErr != nil {
-			return rowsErr
+func (p *postgreSQLQueue) ClaimMessages(ctx context.Context,
+	options types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	return p.claimMessages(ctx, options.Defaults())
+}
+
+func (p *postgreSQLQueue) claimMessages(ctx context.Context,
+	opts *types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	query := fmt.Sprintf(`WITH claimed AS (
+			UPDATE %[1]s 
+			SET retrieval = retrieval + 1, visible_after = $1
+			WHERE id IN (
+				SELECT id FROM %[1]s 
+				WHERE visible_after <= $2
+				ORDER BY %[2]s 
+				FOR UPDATE SKIP LOCKED
+				LIMIT $3
+			)
+			RETURNING id, deduplication_id, payload, priority, visible_after, retrieval, created_at,
+				compression, key_id, blob_key, attributes::TEXT AS attributes
+		)
+		SELECT id, deduplication_id, payload, priority, visible_after, retrieval, created_at,
+			compression, key_id, blob_key, attributes 
+		FROM claimed ORDER BY %[2]s;`, p.table, p.order)
+
+	granted, acquireErr := acquireClaim(ctx, opts)
+	if acquireErr != nil {
+		return nil, acquireErr
+	}
+	var limit *int
+	if granted != 0 {
+		limit = &granted
+	}
+
+	claimStarted := time.Now()
+	rows, queryErr := p.db.Query(ctx, query, common.VisibleAfter(time.Now(), *opts.VisibilityTimeout),
+		time.Now().Unix(), limit)
+	if queryErr != nil {
+		return nil, queryErr
+	}
+	defer rows.Close()
+
+	var messages []types.ReceivedMessage
+	for rows.Next() {
+		var (
+			msg           types.ReceivedMessage
+			encoding      payloadEncoding
+			rawAttributes *string
+		)
+		if scanErr := rows.Scan(&msg.ID, &msg.DeduplicationID, &msg.Payload, &msg.Priority, &msg.VisibleAfter,
+			&msg.Retrieval, &msg.CreatedAt, &encoding.compression, &encoding.keyID,
+			&encoding.blobKey, &rawAttributes); scanErr != nil {
+			return nil, scanErr
 		}
 
-		rows.Close()
-		if rowCount == 0 {
-			time.Sleep(*opts.WaitTime)
+		if decodeErr := decodeMessage(ctx, p.options, &msg, encoding, rawAttributes); decodeErr != nil {
+			p.instrumentation.undecodable(opts, msg, decodeErr)
+			continue
 		}
+		messages = append(messages, msg)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, rowsErr
 	}
+	rows.Close()
+	p.instrumentation.claimed(messages, time.Since(claimStarted))
+
+	if releaseErr := releaseClaim(ctx, opts, granted, len(messages)); releaseErr != nil {
+		return nil, releaseErr
+	}
+	return messages, nil
 }
 
 func (p *postgreSQLQueue) SendMessage(ctx context.Context, message *types.Message) error {
@@ -130,6 +679,12 @@ func (p *postgreSQLQueue) SendMessage(ctx context.Context, message *types.Messag
 }
 
 func (p *postgreSQLQueue) SendMessageBatch(ctx context.Context, messages []*types.Message) error {
+	query := fmt.Sprintf(`INSERT INTO %s 
+		(deduplication_id, payload, priority, visible_after, compression, key_id, blob_key, attributes) 
+		VALUES ($1, $2, $3, COALESCE($4, EXTRACT(EPOCH FROM NOW())), $5, $6, $7, $8::JSONB)
+		ON CONFLICT (deduplication_id) DO NOTHING;`, p.table)
+
+	var blobKeys []*string
 	batch := &pgx.Batch{}
 	for _, message := range messages {
 		var deduplicationID string
@@ -139,21 +694,43 @@ func (p *postgreSQLQueue) SendMessageBatch(ctx context.Context, messages []*type
 			deduplicationID = uuid.NewString()
 		}
 
-		query := fmt.Sprintf(`INSERT INTO %s 
-			(deduplication_id, payload, priority, visible_after) 
-			VALUES ('%s', '%s', %d, %d)
-			ON CONFLICT (deduplication_id) DO NOTHING;`,
-			p.table, deduplicationID, message.Payload, message.Priority, message.VisibleAfter)
+		if validateErr := validatePostgreSQLText(deduplicationID, message.Attributes); validateErr != nil {
+			return errors.Join(validateErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		attributes, attributesErr := encodeAttributes(message.Attributes)
+		if attributesErr != nil {
+			return errors.Join(attributesErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		payload, encoding, encodeErr := encodePayload(ctx, p.options, message.Payload)
+		if encodeErr != nil {
+			return errors.Join(encodeErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+		blobKeys = append(blobKeys, encoding.blobKey)
 
-		batch.Queue(query)
+		batch.Queue(query, deduplicationID, payload, message.Priority, message.VisibleAfter,
+			encoding.compression, encoding.keyID, encoding.blobKey, attributes)
 	}
 
+	var duplicateBlobKeys []*string
 	batchResult := p.db.SendBatch(ctx, batch)
+	for _, blobKey := range blobKeys {
+		tag, execErr := batchResult.Exec()
+		if execErr != nil {
+			return errors.Join(execErr, batchResult.Close(), discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+		if tag.RowsAffected() == 0 {
+			duplicateBlobKeys = append(duplicateBlobKeys, blobKey)
+		}
+	}
+
 	if batchCloseErr := batchResult.Close(); batchCloseErr != nil {
-		return batchCloseErr
+		return errors.Join(batchCloseErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
 	}
+	p.instrumentation.sent(len(messages)-len(duplicateBlobKeys), len(duplicateBlobKeys))
 
-	return nil
+	return discardBlobs(ctx, p.options, collectBlobKeys(duplicateBlobKeys))
 }
 
 func (p *postgreSQLQueue) DeleteMessage(ctx context.Context, id uint) error {
@@ -161,9 +738,19 @@ func (p *postgreSQLQueue) DeleteMessage(ctx context.Context, id uint) error {
 }
 
 func (p *postgreSQLQueue) DeleteMessageBatch(ctx context.Context, ids []uint) error {
-	query := fmt.Sprintf(`DELETE FROM %s WHERE id = ANY($1);`, p.table)
-	_, execErr := p.db.Exec(ctx, query, ids)
-	return execErr
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = ANY($1) RETURNING blob_key;`, p.table)
+	rows, queryErr := p.db.Query(ctx, query, ids)
+	if queryErr != nil {
+		return queryErr
+	}
+
+	blobKeys, collectErr := pgx.CollectRows(rows, pgx.RowTo[*string])
+	if collectErr != nil {
+		return collectErr
+	}
+	p.instrumentation.deleted(len(blobKeys))
+
+	return discardBlobs(ctx, p.options, collectBlobKeys(blobKeys))
 }
 
 func (p *postgreSQLQueue) ChangeMessageVisibility(ctx context.Context, id uint, visibilityTimeout time.Duration) error {
@@ -173,6 +760,258 @@ func (p *postgreSQLQueue) ChangeMessageVisibility(ctx context.Context, id uint,
 func (p *postgreSQLQueue) ChangeMessageVisibilityBatch(ctx context.Context, ids []uint,
 	visibilityTimeout time.Duration) error {
 	query := fmt.Sprintf(`UPDATE %s SET visible_after = $1 WHERE id = ANY($2);`, p.table)
-	_, execErr := p.db.Exec(ctx, query, time.Now().Add(visibilityTimeout).Unix(), ids)
+	_, execErr := p.db.Exec(ctx, query, common.VisibleAfter(time.Now(), visibilityTimeout), ids)
 	return execErr
 }
+
+func (p *postgreSQLQueue) DeleteWhere(ctx context.Context, filter types.MessageFilter) (int, error) {
+	query := `DELETE FROM %[1]s WHERE id IN (SELECT id FROM %[1]s WHERE id > $1 AND %[2]s ORDER BY id LIMIT $2) 
+		RETURNING id, blob_key;`
+
+	return p.filterChunks(ctx, &filter, query, nil, func(blobKeys []*string) error {
+		p.instrumentation.deleted(len(blobKeys))
+		return discardBlobs(ctx, p.options, collectBlobKeys(blobKeys))
+	})
+}
+
+func (p *postgreSQLQueue) RescheduleWhere(ctx context.Context, filter types.MessageFilter,
+	visibilityTimeout time.Duration) (int, error) {
+	query := `UPDATE %[1]s SET visible_after = $1 
+		WHERE id IN (SELECT id FROM %[1]s WHERE id > $2 AND %[2]s ORDER BY id LIMIT $3) 
+		RETURNING id, blob_key;`
+	visibleAfter := common.VisibleAfter(time.Now(), visibilityTimeout)
+
+	return p.filterChunks(ctx, &filter, query, []any{visibleAfter}, nil)
+}
+
+func (p *postgreSQLQueue) filterChunks(ctx context.Context, filter *types.MessageFilter, query string,
+	leading []any, fun func(blobKeys []*string) error) (int, error) {
+	if validateErr := validateFilter(filter.Defaults()); validateErr != nil {
+		return 0, validateErr
+	}
+
+	conditions, args := filterConditions(filter, numberedPlaceholder(len(leading)+2), `attributes ->> %s = %s`)
+	query = fmt.Sprintf(query, p.table, conditions)
+
+	var (
+		lastID   uint
+		affected int
+	)
+	for {
+		chunkArgs := append(append([]any{}, leading...), lastID, *filter.ChunkSize)
+		rows, queryErr := p.db.Query(ctx, query, append(chunkArgs, args...)...)
+		if queryErr != nil {
+			return affected, queryErr
+		}
+
+		var blobKeys []*string
+		for rows.Next() {
+			var (
+				id      uint
+				blobKey *string
+			)
+			if scanErr := rows.Scan(&id, &blobKey); scanErr != nil {
+				rows.Close()
+				return affected, scanErr
+			}
+			lastID = max(lastID, id)
+			blobKeys = append(blobKeys, blobKey)
+		}
+		rows.Close()
+		if rowsErr := rows.Err(); rowsErr != nil {
+			return affected, rowsErr
+		}
+		affected += len(blobKeys)
+
+		if fun != nil {
+			if funErr := fun(blobKeys); funErr != nil {
+				return affected, funErr
+			}
+		}
+
+		if len(blobKeys) < *filter.ChunkSize {
+			return affected, nil
+		}
+	}
+}
+
+func (p *postgreSQLQueue) Export(ctx context.Context, w io.Writer, options ...types.ExportOptions) (int, error) {
+	query := fmt.Sprintf(`SELECT id, deduplication_id, payload, priority, visible_after, retrieval, created_at, 
+		compression, key_id, blob_key, attributes::TEXT FROM %s WHERE id > $1 ORDER BY id LIMIT $2;`, p.table)
+
+	exportOptions := common.First(options)
+	return exportStream(ctx, w, p.options, exportOptions.Defaults(), func(afterID uint, limit int) ([]exportRow, error) {
+		rows, queryErr := p.db.Query(ctx, query, afterID, limit)
+		if queryErr != nil {
+			return nil, queryErr
+		}
+
+		var chunk []exportRow
+		for rows.Next() {
+			var row exportRow
+			if scanErr := rows.Scan(&row.record.ID, &row.record.DeduplicationID, &row.record.Payload,
+				&row.record.Priority, &row.record.VisibleAfter, &row.record.Retrieval, &row.record.CreatedAt,
+				&row.encoding.compression, &row.encoding.keyID, &row.encoding.blobKey,
+				&row.attributes); scanErr != nil {
+				rows.Close()
+				return nil, scanErr
+			}
+			chunk = append(chunk, row)
+		}
+		rows.Close()
+
+		return chunk, rows.Err()
+	})
+}
+
+func (p *postgreSQLQueue) Import(ctx context.Context, r io.Reader, options ...types.ImportOptions) (int, error) {
+	importOptions := common.First(options)
+	return importStream(r, importOptions.Defaults(), func(records []types.ExportRecord) (int, error) {
+		return p.importBatch(ctx, records)
+	})
+}
+
+func (p *postgreSQLQueue) importBatch(ctx context.Context, records []types.ExportRecord) (int, error) {
+	query := fmt.Sprintf(`INSERT INTO %s 
+		(deduplication_id, payload, priority, visible_after, retrieval, created_at, compression, key_id, blob_key, 
+		attributes) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10::JSONB)
+		ON CONFLICT (deduplication_id) DO NOTHING;`, p.table)
+
+	var blobKeys []*string
+	batch := &pgx.Batch{}
+	for _, record := range records {
+		var deduplicationID string
+		if record.DeduplicationID != nil {
+			deduplicationID = *record.DeduplicationID
+		} else {
+			deduplicationID = uuid.NewString()
+		}
+
+		if validateErr := validatePostgreSQLText(deduplicationID, record.Attributes); validateErr != nil {
+			return 0, errors.Join(validateErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		attributes, attributesErr := encodeAttributes(record.Attributes)
+		if attributesErr != nil {
+			return 0, errors.Join(attributesErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		payload, encoding, encodeErr := encodePayload(ctx, p.options, record.Payload)
+		if encodeErr != nil {
+			return 0, errors.Join(encodeErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+		blobKeys = append(blobKeys, encoding.blobKey)
+
+		batch.Queue(query, deduplicationID, payload, record.Priority, record.VisibleAfter, record.Retrieval,
+			record.CreatedAt, encoding.compression, encoding.keyID, encoding.blobKey, attributes)
+	}
+
+	var duplicateBlobKeys []*string
+	batchResult := p.db.SendBatch(ctx, batch)
+	for _, blobKey := range blobKeys {
+		tag, execErr := batchResult.Exec()
+		if execErr != nil {
+			return 0, errors.Join(execErr, batchResult.Close(), discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+		if tag.RowsAffected() == 0 {
+			duplicateBlobKeys = append(duplicateBlobKeys, blobKey)
+		}
+	}
+
+	if batchCloseErr := batchResult.Close(); batchCloseErr != nil {
+		return 0, errors.Join(batchCloseErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+	}
+	p.instrumentation.sent(len(records)-len(duplicateBlobKeys), len(duplicateBlobKeys))
+
+	return len(records) - len(duplicateBlobKeys), discardBlobs(ctx, p.options, collectBlobKeys(duplicateBlobKeys))
+}
+
+func (p *postgreSQLQueue) ReEncrypt(ctx context.Context) (int, error) {
+	if p.options.KeyProvider == nil {
+		return 0, types.ErrKeyProviderNotConfigured
+	}
+
+	currentKeyID, _, keyErr := p.options.KeyProvider.CurrentKey(ctx)
+	if keyErr != nil {
+		return 0, keyErr
+	}
+
+	selectQuery := fmt.Sprintf(`SELECT id, payload, key_id, blob_key FROM %s 
+		WHERE id > $1 AND key_id IS DISTINCT FROM $2 ORDER BY id LIMIT $3;`, p.table)
+	updateQuery := fmt.Sprintf(`UPDATE %s SET payload = $1, key_id = $2, blob_key = $3 
+		WHERE id = $4 AND key_id IS NOT DISTINCT FROM $5 AND blob_key IS NOT DISTINCT FROM $6;`, p.table)
+
+	var (
+		lastID    uint
+		rewritten int
+	)
+	for {
+		rows, queryErr := p.db.Query(ctx, selectQuery, lastID, currentKeyID, reencryptChunkSize)
+		if queryErr != nil {
+			return rewritten, queryErr
+		}
+
+		type row struct {
+			id       uint
+			payload  []byte
+			encoding payloadEncoding
+		}
+		var chunk []row
+		for rows.Next() {
+			var r row
+			if scanErr := rows.Scan(&r.id, &r.payload, &r.encoding.keyID, &r.encoding.blobKey); scanErr != nil {
+				rows.Close()
+				return rewritten, scanErr
+			}
+			chunk = append(chunk, r)
+		}
+		rows.Close()
+		if rowsErr := rows.Err(); rowsErr != nil {
+			return rewritten, rowsErr
+		}
+
+		for _, r := range chunk {
+			reencrypted, reencryptErr := reencryptPayload(ctx, p.options, r.payload, r.encoding)
+			if reencryptErr != nil {
+				return rewritten, reencryptErr
+			}
+
+			tag, execErr := p.db.Exec(ctx, updateQuery, reencrypted.payload, reencrypted.keyID, reencrypted.blobKey,
+				r.id, r.encoding.keyID, r.encoding.blobKey)
+			if execErr != nil {
+				return rewritten, errors.Join(execErr, finishReencrypt(ctx, p.options, r.encoding, reencrypted, false))
+			}
+			applied := tag.RowsAffected() == 1
+			if finishErr := finishReencrypt(ctx, p.options, r.encoding, reencrypted, applied); finishErr != nil {
+				return rewritten, finishErr
+			}
+			rewritten += int(tag.RowsAffected())
+			lastID = r.id
+		}
+
+		if len(chunk) < reencryptChunkSize {
+			return rewritten, nil
+		}
+	}
+}
+
+func (p *postgreSQLQueue) Stats(ctx context.Context) (*types.QueueStats, error) {
+	query := fmt.Sprintf(`SELECT COUNT(*), COUNT(*) FILTER (WHERE visible_after <= $1), MIN(created_at) 
+		FROM %s;`, p.table)
+
+	var (
+		stats    types.QueueStats
+		oldestAt *int64
+	)
+	now := time.Now()
+	if scanErr := p.db.QueryRow(ctx, query, now.Unix()).Scan(&stats.Depth, &stats.Visible,
+		&oldestAt); scanErr != nil {
+		return nil, scanErr
+	}
+	if oldestAt != nil {
+		stats.OldestAge = now.Sub(time.Unix(*oldestAt, 0))
+	}
+
+	p.instrumentation.stats(&stats)
+	return &stats, nil
+}
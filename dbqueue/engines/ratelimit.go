@@ -0,0 +1,104 @@
+package engines
+
+import (
+	"context"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"time"
+)
+
+const rateLimitTable = "dbqueue_rate_limits"
+
+type bucketState struct {
+	tokens    float64
+	updatedAt int64
+	version   int64
+}
+
+type storedRateLimiter struct {
+	rate  float64
+	burst float64
+	load  func(ctx context.Context) (bucketState, error)
+	store func(ctx context.Context, previous, next bucketState) (bool, error)
+}
+
+func validateRateLimit(rate float64, burst int) error {
+	if rate <= 0 || burst < 1 {
+		return fmt.Errorf("%w: rate %v, burst %d", types.ErrInvalidRateLimit, rate, burst)
+	}
+	return nil
+}
+
+func (p *storedRateLimiter) Acquire(ctx context.Context, max int) (int, error) {
+	for {
+		state, loadErr := p.load(ctx)
+		if loadErr != nil {
+			return 0, loadErr
+		}
+
+		now := time.Now().UnixMicro()
+		tokens := p.refill(state, now)
+		if tokens < 1 {
+			wait := time.Duration((1 - tokens) / p.rate * float64(time.Second))
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		granted := int(tokens)
+		if max > 0 {
+			granted = min(granted, max)
+		}
+		stored, storeErr := p.store(ctx, state, bucketState{
+			tokens:    tokens - float64(granted),
+			updatedAt: now,
+			version:   state.version + 1,
+		})
+		if storeErr != nil {
+			return 0, storeErr
+		}
+		if stored {
+			return granted, nil
+		}
+	}
+}
+
+func (p *storedRateLimiter) Release(ctx context.Context, count int) error {
+	for {
+		state, loadErr := p.load(ctx)
+		if loadErr != nil {
+			return loadErr
+		}
+
+		now := time.Now().UnixMicro()
+		stored, storeErr := p.store(ctx, state, bucketState{
+			tokens:    min(p.burst, p.refill(state, now)+float64(count)),
+			updatedAt: now,
+			version:   state.version + 1,
+		})
+		if storeErr != nil || stored {
+			return storeErr
+		}
+	}
+}
+
+func (p *storedRateLimiter) refill(state bucketState, now int64) float64 {
+	return min(p.burst, state.tokens+float64(now-state.updatedAt)/float64(time.Second/time.Microsecond)*p.rate)
+}
+
+func acquireClaim(ctx context.Context, options *types.ReceiveMessageOptions) (int, error) {
+	if options.RateLimiter == nil {
+		return *options.MaxNumberOfMessages, nil
+	}
+	return options.RateLimiter.Acquire(ctx, *options.MaxNumberOfMessages)
+}
+
+func releaseClaim(ctx context.Context, options *types.ReceiveMessageOptions, granted, claimed int) error {
+	if options.RateLimiter == nil || granted <= claimed {
+		return nil
+	}
+	return options.RateLimiter.Release(ctx, granted-claimed)
+}
@@ -7,128 +7,576 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
 	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
-	"strconv"
+	"io"
+	"sort"
 	"time"
 )
 
 type sqliteEngine struct {
-	db *sql.DB
+	db      *sql.DB
+	owned   bool
+	options *types.EngineOptions
 }
 type sqliteQueue struct {
-	db    *sql.DB
-	table string
+	db              *sql.DB
+	table           string
+	options         *types.QueueOptions
+	instrumentation *instrumentation
+	order           string
+	agingSeconds    int64
 }
 
-func NewSQLiteEngine(_ context.Context, conn string) (types.Engine, error) {
+var sqliteMigrations = []migration{
+	{
+		version: 1,
+		columns: []string{"id", "deduplication_id", "payload", "priority", "retrieval", "visible_after", "created_at"},
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS %[1]s (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				deduplication_id TEXT NOT NULL UNIQUE,
+				payload BLOB,
+				priority INTEGER NOT NULL DEFAULT 0,
+				retrieval INTEGER NOT NULL DEFAULT 0,
+				visible_after INTEGER NOT NULL DEFAULT (strftime('%%s', 'now')),
+				created_at INTEGER NOT NULL DEFAULT (strftime('%%s', 'now')));`,
+		},
+	},
+	{
+		version: 2,
+		columns: []string{"compression", "key_id", "blob_key"},
+		statements: []string{
+			`ALTER TABLE %[1]s ADD COLUMN compression TEXT;`,
+			`ALTER TABLE %[1]s ADD COLUMN key_id TEXT;`,
+			`ALTER TABLE %[1]s ADD COLUMN blob_key TEXT;`,
+		},
+	},
+	{
+		version:    3,
+		columns:    []string{"attributes"},
+		statements: []string{`ALTER TABLE %[1]s ADD COLUMN attributes TEXT;`},
+	},
+}
+
+func NewSQLiteEngine(_ context.Context, conn string, options ...types.EngineOptions) (types.Engine, error) {
 	db, newErr := sql.Open("sqlite3", conn)
 	if newErr != nil {
 		return nil, newErr
 	}
+
+	engineOptions := common.First(options)
+	configureSQLPool(db, engineOptions.Defaults())
 	return &sqliteEngine{
-		db: db,
+		db:      db,
+		owned:   true,
+		options: &engineOptions,
 	}, nil
 }
 
-func (p *sqliteEngine) OpenQueue(ctx context.Context, name string) (types.Queue, error) {
-	var exists int
-	query := `SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?;`
-	if queryErr := p.db.QueryRowContext(ctx, query, name).Scan(&exists); queryErr != nil {
-		return nil, queryErr
+func NewSQLiteEngineFromDB(_ context.Context, db *sql.DB, options ...types.EngineOptions) (types.Engine, error) {
+	engineOptions := common.First(options)
+	return &sqliteEngine{
+		db:      db,
+		options: engineOptions.Defaults(),
+	}, nil
+}
+
+func (p *sqliteEngine) Close() error {
+	if p.owned {
+		return p.db.Close()
 	}
+	return nil
+}
 
-	if exists == 0 {
-		return nil, types.ErrQueueNotFound
+func (p *sqliteEngine) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *sqliteEngine) Health(ctx context.Context) (*types.Health, error) {
+	started := time.Now()
+	if pingErr := p.Ping(ctx); pingErr != nil {
+		return nil, pingErr
+	}
+	health := &types.Health{
+		Latency: time.Since(started),
 	}
 
-	return &sqliteQueue{
-		db:    p.db,
-		table: name,
+	registry, existsErr := p.tableExists(ctx, p.db, registryTable)
+	if existsErr != nil {
+		return health, existsErr
+	}
+	health.Registry = registry
+
+	names, namesErr := p.queueNames(ctx)
+	if namesErr != nil {
+		return health, namesErr
+	}
+
+	var queueErrs []error
+	for _, name := range names {
+		version, _, versionErr := p.queueVersion(ctx, p.db, name)
+		if versionErr == nil {
+			versionErr = checkSchemaVersion(sqliteMigrations, name, version)
+		}
+		health.Queues = append(health.Queues, types.QueueHealth{
+			Name:    name,
+			Version: version,
+			Err:     versionErr,
+		})
+		queueErrs = append(queueErrs, versionErr)
+	}
+	return health, errors.Join(queueErrs...)
+}
+
+func (p *sqliteEngine) RateLimiter(ctx context.Context, name string, rate float64,
+	burst int) (types.RateLimiter, error) {
+	if validateErr := validateRateLimit(rate, burst); validateErr != nil {
+		return nil, validateErr
+	}
+
+	table := quoteSQLiteIdentifier(rateLimitTable)
+	createQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		name TEXT PRIMARY KEY,
+		tokens REAL NOT NULL,
+		updated_at INTEGER NOT NULL,
+		version INTEGER NOT NULL);`, table)
+	if _, execErr := p.db.ExecContext(ctx, createQuery); execErr != nil {
+		return nil, execErr
+	}
+
+	insertQuery := fmt.Sprintf(`INSERT OR IGNORE INTO %s (name, tokens, updated_at, version) 
+		VALUES (?, ?, ?, 0);`, table)
+	if _, execErr := p.db.ExecContext(ctx, insertQuery, name, burst, time.Now().UnixMicro()); execErr != nil {
+		return nil, execErr
+	}
+
+	loadQuery := fmt.Sprintf(`SELECT tokens, updated_at, version FROM %s WHERE name = ?;`, table)
+	storeQuery := fmt.Sprintf(`UPDATE %s SET tokens = ?, updated_at = ?, version = ? 
+		WHERE name = ? AND version = ?;`, table)
+	return &storedRateLimiter{
+		rate:  rate,
+		burst: float64(burst),
+		load: func(ctx context.Context) (bucketState, error) {
+			var state bucketState
+			scanErr := p.db.QueryRowContext(ctx, loadQuery, name).Scan(&state.tokens, &state.updatedAt,
+				&state.version)
+			return state, scanErr
+		},
+		store: func(ctx context.Context, previous, next bucketState) (bool, error) {
+			result, execErr := p.db.ExecContext(ctx, storeQuery, next.tokens, next.updatedAt, next.version,
+				name, previous.version)
+			if execErr != nil {
+				return false, execErr
+			}
+			affected, affectedErr := result.RowsAffected()
+			return affected == 1, affectedErr
+		},
 	}, nil
 }
 
-func (p *sqliteEngine) CreateQueue(ctx context.Context, name string) (types.Queue, error) {
-	query := fmt.Sprintf(
-		`CREATE TABLE IF NOT EXISTS %s (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				deduplication_id TEXT NOT NULL UNIQUE,
-				payload BLOB,
-				priority INTEGER NOT NULL DEFAULT 0,
-				retrieval INTEGER NOT NULL DEFAULT 0,
-				visible_after INTEGER NOT NULL DEFAULT (strftime('%%s', 'now')),
-				created_at INTEGER NOT NULL DEFAULT (strftime('%%s', 'now')));`, name)
-	_, execErr := p.db.ExecContext(ctx, query)
+func (p *sqliteEngine) OpenQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return nil, validateErr
+	}
+
+	version, exists, versionErr := p.queueVersion(ctx, p.db, name)
+	if versionErr != nil {
+		return nil, versionErr
+	}
+
+	if !exists {
+		return nil, types.ErrQueueNotFound
+	}
+
+	if checkErr := checkSchemaVersion(sqliteMigrations, name, version); checkErr != nil {
+		return nil, checkErr
+	}
+
+	return p.newQueue(ctx, name, options)
+}
+
+func (p *sqliteEngine) CreateQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return nil, validateErr
+	}
+
+	if migrateErr := p.migrateQueue(ctx, name, true); migrateErr != nil {
+		return nil, migrateErr
+	}
+
+	return p.newQueue(ctx, name, options)
+}
+
+func (p *sqliteEngine) newQueue(ctx context.Context, name string,
+	options []types.QueueOptions) (types.Queue, error) {
+	queueOptions := common.First(options)
+	seconds, agingErr := agingSeconds(&queueOptions)
+	if agingErr != nil {
+		return nil, agingErr
+	}
+
+	if seconds > 0 {
+		if indexErr := p.createAgingIndex(ctx, name, seconds); indexErr != nil {
+			return nil, indexErr
+		}
+	}
+
 	return &sqliteQueue{
-		db:    p.db,
-		table: name,
-	}, errors.Join(execErr)
+		db:              p.db,
+		table:           quoteSQLiteIdentifier(name),
+		options:         queueOptions.Defaults(),
+		instrumentation: newInstrumentation(name, p.options),
+		order:           claimOrder("priority", seconds),
+		agingSeconds:    seconds,
+	}, nil
 }
 
-func (p *sqliteEngine) DeleteQueue(ctx context.Context, name string) error {
-	query := fmt.Sprintf("DROP TABLE IF EXISTS %s;", name)
-	_, execErr := p.db.ExecContext(ctx, query)
-	return execErr
+func (p *sqliteEngine) createAgingIndex(ctx context.Context, name string, seconds int64) error {
+	query := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (%s DESC, id ASC);`,
+		quoteSQLiteIdentifier(agingIndexName(name, seconds)), quoteSQLiteIdentifier(name),
+		agingExpression("priority", seconds))
+	if _, indexErr := p.db.ExecContext(ctx, query); indexErr != nil {
+		return indexErr
+	}
+
+	rows, queryErr := p.db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ?;`,
+		name)
+	if queryErr != nil {
+		return queryErr
+	}
+	indexes, scanErr := scanIndexNames(rows)
+	if scanErr != nil {
+		return scanErr
+	}
+
+	for _, stale := range staleAgingIndexes(name, seconds, indexes) {
+		query = fmt.Sprintf(`DROP INDEX IF EXISTS %s;`, quoteSQLiteIdentifier(stale))
+		if _, dropErr := p.db.ExecContext(ctx, query); dropErr != nil {
+			return dropErr
+		}
+	}
+	return nil
 }
 
-func (p *sqliteEngine) PurgeQueue(ctx context.Context, name string) error {
-	query := fmt.Sprintf("DELETE FROM %s;", name)
-	_, execErr := p.db.ExecContext(ctx, query)
+func (p *sqliteEngine) DeleteQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	queueOptions := common.First(options)
+	if queueOptions.BlobStore != nil {
+		exists, existsErr := p.tableExists(ctx, p.db, name)
+		if existsErr != nil {
+			return existsErr
+		}
+		if exists {
+			if purgeErr := p.PurgeQueue(ctx, name, queueOptions); purgeErr != nil {
+				return purgeErr
+			}
+		}
+	}
+
+	query := fmt.Sprintf("DROP TABLE IF EXISTS %s;", quoteSQLiteIdentifier(name))
+	if _, execErr := p.db.ExecContext(ctx, query); execErr != nil {
+		return execErr
+	}
+
+	registered, registeredErr := p.tableExists(ctx, p.db, registryTable)
+	if registeredErr != nil || !registered {
+		return registeredErr
+	}
+
+	registryQuery := fmt.Sprintf("DELETE FROM %s WHERE name = ?;", quoteSQLiteIdentifier(registryTable))
+	_, execErr := p.db.ExecContext(ctx, registryQuery, name)
 	return execErr
 }
 
-func (p *sqliteQueue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
-	options types.ReceiveMessageOptions) error {
-	opts := options.Defaults()
-	limit := strconv.Itoa(*opts.MaxNumberOfMessages)
-	if *opts.MaxNumberOfMessages == 0 {
-		limit = "-1"
+func (p *sqliteEngine) PurgeQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
 	}
 
-	for {
-		query := fmt.Sprintf(`UPDATE %s 
-		SET retrieval = retrieval + 1, visible_after = %d
-		WHERE id IN (
-			SELECT id FROM %s 
-			WHERE visible_after < %d
-			ORDER BY priority DESC, id ASC 
-			LIMIT %s
-		)
-		RETURNING id, deduplication_id, payload, priority, visible_after, retrieval, created_at;`,
-			p.table, time.Now().Add(*opts.VisibilityTimeout).Unix(), p.table, time.Now().Unix(), limit)
+	exists, existsErr := p.tableExists(ctx, p.db, name)
+	if existsErr != nil {
+		return existsErr
+	}
+	if !exists {
+		return types.ErrQueueNotFound
+	}
+
+	queueOptions := common.First(options)
+	if queueOptions.BlobStore == nil {
+		query := fmt.Sprintf("DELETE FROM %s;", quoteSQLiteIdentifier(name))
+		_, execErr := p.db.ExecContext(ctx, query)
+		return execErr
+	}
+
+	transaction, beginErr := p.db.BeginTx(ctx, nil)
+	if beginErr != nil {
+		return beginErr
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE blob_key IS NOT NULL RETURNING blob_key;", quoteSQLiteIdentifier(name))
+	rows, queryErr := transaction.QueryContext(ctx, query)
+	if queryErr != nil {
+		return errors.Join(queryErr, transaction.Rollback())
+	}
 
-		rows, err := p.db.QueryContext(ctx, query)
-		if err != nil {
-			return err
+	var blobKeys []string
+	for rows.Next() {
+		var blobKey string
+		if scanErr := rows.Scan(&blobKey); scanErr != nil {
+			return errors.Join(scanErr, rows.Close(), transaction.Rollback())
 		}
+		blobKeys = append(blobKeys, blobKey)
+	}
+	if rowsErr := errors.Join(rows.Err(), rows.Close()); rowsErr != nil {
+		return errors.Join(rowsErr, transaction.Rollback())
+	}
 
-		var messages []*types.ReceivedMessage
-		for rows.Next() {
-			var newMessage types.ReceivedMessage
-			if scanErr := rows.Scan(&newMessage.ID, &newMessage.DeduplicationID, &newMessage.Payload,
-				&newMessage.Priority, &newMessage.VisibleAfter, &newMessage.Retrieval,
-				&newMessage.CreatedAt); scanErr != nil {
-				return scanErr
+	deleteQuery := fmt.Sprintf("DELETE FROM %s;", quoteSQLiteIdentifier(name))
+	if _, execErr := transaction.ExecContext(ctx, deleteQuery); execErr != nil {
+		return errors.Join(execErr, transaction.Rollback())
+	}
+
+	if commitErr := transaction.Commit(); commitErr != nil {
+		return commitErr
+	}
+
+	return discardBlobs(ctx, queueOptions.Defaults(), blobKeys)
+}
+
+func (p *sqliteEngine) MigrateQueue(ctx context.Context, name string) error {
+	if validateErr := validateQueueName(name); validateErr != nil {
+		return validateErr
+	}
+
+	return p.migrateQueue(ctx, name, false)
+}
+
+func (p *sqliteEngine) ListQueues(ctx context.Context) ([]string, error) {
+	return p.queueNames(ctx)
+}
+
+func (p *sqliteEngine) MigrateAll(ctx context.Context) error {
+	names, namesErr := p.queueNames(ctx)
+	if namesErr != nil {
+		return namesErr
+	}
+
+	var migrateErrs []error
+	for _, name := range names {
+		migrateErrs = append(migrateErrs, p.MigrateQueue(ctx, name))
+	}
+	return errors.Join(migrateErrs...)
+}
+
+func (p *sqliteEngine) migrateQueue(ctx context.Context, name string, create bool) error {
+	transaction, beginErr := p.db.BeginTx(ctx, nil)
+	if beginErr != nil {
+		return beginErr
+	}
+
+	registryQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				name TEXT PRIMARY KEY,
+				version INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL);`, quoteSQLiteIdentifier(registryTable))
+	if _, execErr := transaction.ExecContext(ctx, registryQuery); execErr != nil {
+		return errors.Join(execErr, transaction.Rollback())
+	}
+
+	version, exists, versionErr := p.queueVersion(ctx, transaction, name)
+	if versionErr != nil {
+		return errors.Join(versionErr, transaction.Rollback())
+	}
+
+	if !exists && !create {
+		return errors.Join(types.ErrQueueNotFound, transaction.Rollback())
+	}
+
+	if exists && version == 0 {
+		return errors.Join(fmt.Errorf("%w: %s", types.ErrInvalidQueueSchema, name), transaction.Rollback())
+	}
+
+	pending, pendingErr := pendingMigrations(sqliteMigrations, name, version)
+	if pendingErr != nil {
+		return errors.Join(pendingErr, transaction.Rollback())
+	}
+
+	for _, m := range pending {
+		for _, statement := range m.statements {
+			if _, execErr := transaction.ExecContext(ctx, fmt.Sprintf(statement, quoteSQLiteIdentifier(name))); execErr != nil {
+				return errors.Join(execErr, transaction.Rollback())
 			}
+		}
+	}
+
+	upsertQuery := fmt.Sprintf(`INSERT INTO %s (name, version, updated_at) VALUES (?, ?, ?) 
+		ON CONFLICT (name) DO UPDATE SET version = excluded.version, updated_at = excluded.updated_at;`, quoteSQLiteIdentifier(registryTable))
+	if _, execErr := transaction.ExecContext(ctx, upsertQuery, name, latestSchemaVersion(sqliteMigrations),
+		time.Now().Unix()); execErr != nil {
+		return errors.Join(execErr, transaction.Rollback())
+	}
+
+	return transaction.Commit()
+}
+
+func (p *sqliteEngine) queueVersion(ctx context.Context, querier sqlQuerier, name string) (int, bool, error) {
+	exists, existsErr := p.tableExists(ctx, querier, name)
+	if existsErr != nil || !exists {
+		return 0, false, existsErr
+	}
+
+	registered, registeredErr := p.tableExists(ctx, querier, registryTable)
+	if registeredErr != nil {
+		return 0, true, registeredErr
+	}
 
-			messages = append(messages, &newMessage)
+	if registered {
+		var version int
+		query := fmt.Sprintf("SELECT version FROM %s WHERE name = ?;", quoteSQLiteIdentifier(registryTable))
+		scanErr := querier.QueryRowContext(ctx, query, name).Scan(&version)
+		if scanErr == nil {
+			return version, true, nil
 		}
+		if !errors.Is(scanErr, sql.ErrNoRows) {
+			return 0, true, scanErr
+		}
+	}
+
+	rows, queryErr := querier.QueryContext(ctx, `SELECT ?, name FROM pragma_table_info(?);`, name, name)
+	if queryErr != nil {
+		return 0, true, queryErr
+	}
+	defer rows.Close()
 
-		if rowsErr := rows.Err(); rowsErr != nil {
-			return rowsErr
+	columns, groupErr := groupColumns(rows)
+	return detectSchemaVersion(sqliteMigrations, columns[name]), true, groupErr
+}
+
+func (p *sqliteEngine) queueNames(ctx context.Context) ([]string, error) {
+	query := `SELECT m.name, c.name FROM sqlite_master AS m JOIN pragma_table_info(m.name) AS c 
+		WHERE m.type = 'table' AND m.name <> ?;`
+	rows, queryErr := p.db.QueryContext(ctx, query, registryTable)
+	if queryErr != nil {
+		return nil, queryErr
+	}
+	defer rows.Close()
+
+	columns, groupErr := groupColumns(rows)
+	if groupErr != nil {
+		return nil, groupErr
+	}
+
+	var names []string
+	for table, tableColumns := range columns {
+		if detectSchemaVersion(sqliteMigrations, tableColumns) != 0 {
+			names = append(names, table)
 		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
 
-		if closeErr := rows.Close(); closeErr != nil {
-			return closeErr
+func (p *sqliteEngine) tableExists(ctx context.Context, querier sqlQuerier, name string) (bool, error) {
+	var exists int
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?;`
+	queryErr := querier.QueryRowContext(ctx, query, name).Scan(&exists)
+	return exists != 0, queryErr
+}
+
+func (p *sqliteQueue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	return p.instrumentation.stopped(p.receiveMessage(ctx, fun, options))
+}
+
+func (p *sqliteQueue) receiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	opts := options.Defaults()
+	for {
+		messages, claimErr := p.claimMessages(ctx, opts)
+		if claimErr != nil {
+			return claimErr
 		}
 
 		for _, message := range messages {
-			fun(*message)
+			p.instrumentation.deliver(message, fun)
 		}
 
 		if len(messages) == 0 {
-			time.Sleep(*opts.WaitTime)
+			time.Sleep(opts.PollInterval())
+		}
+	}
+}
+
+func (p *sqliteQueue) ClaimMessages(ctx context.Context,
+	options types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	return p.claimMessages(ctx, options.Defaults())
+}
+
+func (p *sqliteQueue) claimMessages(ctx context.Context,
+	opts *types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	query := fmt.Sprintf(`UPDATE %s 
+		SET retrieval = retrieval + 1, visible_after = ?
+		WHERE id IN (
+			SELECT id FROM %s 
+			WHERE visible_after <= ?
+			ORDER BY %s 
+			LIMIT ?
+		)
+		RETURNING id, deduplication_id, payload, priority, visible_after, retrieval, created_at,
+			compression, key_id, blob_key, attributes;`, p.table, p.table, p.order)
+
+	granted, acquireErr := acquireClaim(ctx, opts)
+	if acquireErr != nil {
+		return nil, acquireErr
+	}
+	limit := granted
+	if limit == 0 {
+		limit = -1
+	}
+
+	claimStarted := time.Now()
+	rows, queryErr := p.db.QueryContext(ctx, query, common.VisibleAfter(time.Now(), *opts.VisibilityTimeout),
+		time.Now().Unix(), limit)
+	if queryErr != nil {
+		return nil, queryErr
+	}
+
+	var messages []types.ReceivedMessage
+	for rows.Next() {
+		var (
+			newMessage    types.ReceivedMessage
+			encoding      payloadEncoding
+			rawAttributes *string
+		)
+		if scanErr := rows.Scan(&newMessage.ID, &newMessage.DeduplicationID, &newMessage.Payload,
+			&newMessage.Priority, &newMessage.VisibleAfter, &newMessage.Retrieval,
+			&newMessage.CreatedAt, &encoding.compression, &encoding.keyID,
+			&encoding.blobKey, &rawAttributes); scanErr != nil {
+			return nil, errors.Join(scanErr, rows.Close())
 		}
+
+		if decodeErr := decodeMessage(ctx, p.options, &newMessage, encoding, rawAttributes); decodeErr != nil {
+			p.instrumentation.undecodable(opts, newMessage, decodeErr)
+			continue
+		}
+		messages = append(messages, newMessage)
+	}
+
+	if rowsErr := errors.Join(rows.Err(), rows.Close()); rowsErr != nil {
+		return nil, rowsErr
+	}
+	sortClaimed(messages, p.agingSeconds)
+	p.instrumentation.claimed(messages, time.Since(claimStarted))
+
+	if releaseErr := releaseClaim(ctx, opts, granted, len(messages)); releaseErr != nil {
+		return nil, releaseErr
 	}
+	return messages, nil
 }
 
 func (p *sqliteQueue) SendMessage(ctx context.Context, message *types.Message) error {
@@ -142,14 +590,15 @@ func (p *sqliteQueue) SendMessageBatch(ctx context.Context, messages []*types.Me
 	}
 
 	query := fmt.Sprintf(`INSERT OR IGNORE INTO %s 
-		(deduplication_id, payload, priority, visible_after) 
-		VALUES (?, ?, ?, COALESCE(?, strftime('%%s','now')));`, p.table)
+		(deduplication_id, payload, priority, visible_after, compression, key_id, blob_key, attributes) 
+		VALUES (?, ?, ?, COALESCE(?, strftime('%%s','now')), ?, ?, ?, ?);`, p.table)
 
 	statement, prepareErr := transaction.Prepare(query)
 	if prepareErr != nil {
 		return errors.Join(prepareErr, transaction.Rollback())
 	}
 
+	var blobKeys, duplicateBlobKeys []*string
 	for _, message := range messages {
 		var deduplicationID string
 		if message.DeduplicationID == nil {
@@ -158,13 +607,37 @@ func (p *sqliteQueue) SendMessageBatch(ctx context.Context, messages []*types.Me
 			deduplicationID = *message.DeduplicationID
 		}
 
-		_, execErr := statement.Exec(deduplicationID, message.Payload, message.Priority, nil)
+		attributes, attributesErr := encodeAttributes(message.Attributes)
+		if attributesErr != nil {
+			return errors.Join(attributesErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		payload, encoding, encodeErr := encodePayload(ctx, p.options, message.Payload)
+		if encodeErr != nil {
+			return errors.Join(encodeErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+		blobKeys = append(blobKeys, encoding.blobKey)
+
+		result, execErr := statement.Exec(deduplicationID, payload, message.Priority, message.VisibleAfter,
+			encoding.compression, encoding.keyID, encoding.blobKey, attributes)
 		if execErr != nil {
-			return errors.Join(execErr, transaction.Rollback())
+			return errors.Join(execErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		if affected, affectedErr := result.RowsAffected(); affectedErr == nil && affected == 0 {
+			duplicateBlobKeys = append(duplicateBlobKeys, encoding.blobKey)
 		}
 	}
 
-	return errors.Join(transaction.Commit(), statement.Close())
+	if commitErr := errors.Join(transaction.Commit(), statement.Close()); commitErr != nil {
+		return errors.Join(commitErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+	}
+	p.instrumentation.sent(len(messages)-len(duplicateBlobKeys), len(duplicateBlobKeys))
+
+	return discardBlobs(ctx, p.options, collectBlobKeys(duplicateBlobKeys))
 }
 
 func (p *sqliteQueue) DeleteMessage(ctx context.Context, id uint) error {
@@ -172,20 +645,28 @@ func (p *sqliteQueue) DeleteMessage(ctx context.Context, id uint) error {
 }
 
 func (p *sqliteQueue) DeleteMessageBatch(ctx context.Context, ids []uint) error {
-	query := fmt.Sprintf(`DELETE FROM %s WHERE id = ?;`, p.table)
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = ? RETURNING blob_key;`, p.table)
 	statement, prepareErr := p.db.PrepareContext(ctx, query)
 	if prepareErr != nil {
 		return prepareErr
 	}
 	defer statement.Close()
 
+	var blobKeys []*string
 	for _, id := range ids {
-		if _, execErr := statement.Exec(id); execErr != nil {
-			return execErr
+		var blobKey *string
+		scanErr := statement.QueryRowContext(ctx, id).Scan(&blobKey)
+		if errors.Is(scanErr, sql.ErrNoRows) {
+			continue
 		}
+		if scanErr != nil {
+			return scanErr
+		}
+		blobKeys = append(blobKeys, blobKey)
 	}
+	p.instrumentation.deleted(len(blobKeys))
 
-	return nil
+	return discardBlobs(ctx, p.options, collectBlobKeys(blobKeys))
 }
 
 func (p *sqliteQueue) ChangeMessageVisibility(ctx context.Context, id uint, visibilityTimeout time.Duration) error {
@@ -201,7 +682,7 @@ func (p *sqliteQueue) ChangeMessageVisibilityBatch(ctx context.Context, ids []ui
 	}
 	defer statement.Close()
 
-	visibleAfter := time.Now().Add(visibilityTimeout).Unix()
+	visibleAfter := common.VisibleAfter(time.Now(), visibilityTimeout)
 	for _, id := range ids {
 		if _, execErr := statement.Exec(visibleAfter, id); execErr != nil {
 			return execErr
@@ -210,3 +691,256 @@ func (p *sqliteQueue) ChangeMessageVisibilityBatch(ctx context.Context, ids []ui
 
 	return nil
 }
+
+func (p *sqliteQueue) DeleteWhere(ctx context.Context, filter types.MessageFilter) (int, error) {
+	query := `DELETE FROM %[1]s WHERE id IN (SELECT id FROM %[1]s WHERE id > ? AND %[2]s ORDER BY id LIMIT ?) 
+		RETURNING id, blob_key;`
+
+	return p.filterChunks(ctx, &filter, query, nil, func(blobKeys []*string) error {
+		p.instrumentation.deleted(len(blobKeys))
+		return discardBlobs(ctx, p.options, collectBlobKeys(blobKeys))
+	})
+}
+
+func (p *sqliteQueue) RescheduleWhere(ctx context.Context, filter types.MessageFilter,
+	visibilityTimeout time.Duration) (int, error) {
+	query := `UPDATE %[1]s SET visible_after = ? 
+		WHERE id IN (SELECT id FROM %[1]s WHERE id > ? AND %[2]s ORDER BY id LIMIT ?) 
+		RETURNING id, blob_key;`
+	visibleAfter := common.VisibleAfter(time.Now(), visibilityTimeout)
+
+	return p.filterChunks(ctx, &filter, query, []any{visibleAfter}, nil)
+}
+
+func (p *sqliteQueue) filterChunks(ctx context.Context, filter *types.MessageFilter, query string,
+	leading []any, fun func(blobKeys []*string) error) (int, error) {
+	if validateErr := validateFilter(filter.Defaults()); validateErr != nil {
+		return 0, validateErr
+	}
+
+	conditions, args := filterConditions(filter, questionPlaceholder(),
+		`EXISTS (SELECT 1 FROM json_each(attributes) WHERE key = %s AND value = %s)`)
+	query = fmt.Sprintf(query, p.table, conditions)
+
+	var (
+		lastID   uint
+		affected int
+	)
+	for {
+		chunkArgs := append(append([]any{}, leading...), lastID)
+		chunkArgs = append(append(chunkArgs, args...), *filter.ChunkSize)
+		rows, queryErr := p.db.QueryContext(ctx, query, chunkArgs...)
+		if queryErr != nil {
+			return affected, queryErr
+		}
+
+		var blobKeys []*string
+		for rows.Next() {
+			var (
+				id      uint
+				blobKey *string
+			)
+			if scanErr := rows.Scan(&id, &blobKey); scanErr != nil {
+				return affected, errors.Join(scanErr, rows.Close())
+			}
+			lastID = max(lastID, id)
+			blobKeys = append(blobKeys, blobKey)
+		}
+		if rowsErr := errors.Join(rows.Err(), rows.Close()); rowsErr != nil {
+			return affected, rowsErr
+		}
+		affected += len(blobKeys)
+
+		if fun != nil {
+			if funErr := fun(blobKeys); funErr != nil {
+				return affected, funErr
+			}
+		}
+
+		if len(blobKeys) < *filter.ChunkSize {
+			return affected, nil
+		}
+	}
+}
+
+func (p *sqliteQueue) Export(ctx context.Context, w io.Writer, options ...types.ExportOptions) (int, error) {
+	query := fmt.Sprintf(`SELECT id, deduplication_id, payload, priority, visible_after, retrieval, created_at, 
+		compression, key_id, blob_key, attributes FROM %s WHERE id > ? ORDER BY id LIMIT ?;`, p.table)
+
+	exportOptions := common.First(options)
+	return exportStream(ctx, w, p.options, exportOptions.Defaults(), func(afterID uint, limit int) ([]exportRow, error) {
+		rows, queryErr := p.db.QueryContext(ctx, query, afterID, limit)
+		if queryErr != nil {
+			return nil, queryErr
+		}
+
+		var chunk []exportRow
+		for rows.Next() {
+			var row exportRow
+			if scanErr := rows.Scan(&row.record.ID, &row.record.DeduplicationID, &row.record.Payload,
+				&row.record.Priority, &row.record.VisibleAfter, &row.record.Retrieval, &row.record.CreatedAt,
+				&row.encoding.compression, &row.encoding.keyID, &row.encoding.blobKey,
+				&row.attributes); scanErr != nil {
+				return nil, errors.Join(scanErr, rows.Close())
+			}
+			chunk = append(chunk, row)
+		}
+
+		return chunk, errors.Join(rows.Err(), rows.Close())
+	})
+}
+
+func (p *sqliteQueue) Import(ctx context.Context, r io.Reader, options ...types.ImportOptions) (int, error) {
+	importOptions := common.First(options)
+	return importStream(r, importOptions.Defaults(), func(records []types.ExportRecord) (int, error) {
+		return p.importBatch(ctx, records)
+	})
+}
+
+func (p *sqliteQueue) importBatch(ctx context.Context, records []types.ExportRecord) (int, error) {
+	transaction, beginTransactionErr := p.db.BeginTx(ctx, nil)
+	if beginTransactionErr != nil {
+		return 0, beginTransactionErr
+	}
+
+	query := fmt.Sprintf(`INSERT OR IGNORE INTO %s 
+		(deduplication_id, payload, priority, visible_after, retrieval, created_at, compression, key_id, blob_key, 
+		attributes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`, p.table)
+
+	statement, prepareErr := transaction.Prepare(query)
+	if prepareErr != nil {
+		return 0, errors.Join(prepareErr, transaction.Rollback())
+	}
+
+	var blobKeys, duplicateBlobKeys []*string
+	for _, record := range records {
+		var deduplicationID string
+		if record.DeduplicationID == nil {
+			deduplicationID = uuid.NewString()
+		} else {
+			deduplicationID = *record.DeduplicationID
+		}
+
+		attributes, attributesErr := encodeAttributes(record.Attributes)
+		if attributesErr != nil {
+			return 0, errors.Join(attributesErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		payload, encoding, encodeErr := encodePayload(ctx, p.options, record.Payload)
+		if encodeErr != nil {
+			return 0, errors.Join(encodeErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+		blobKeys = append(blobKeys, encoding.blobKey)
+
+		result, execErr := statement.Exec(deduplicationID, payload, record.Priority, record.VisibleAfter,
+			record.Retrieval, record.CreatedAt, encoding.compression, encoding.keyID, encoding.blobKey, attributes)
+		if execErr != nil {
+			return 0, errors.Join(execErr, transaction.Rollback(),
+				discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+		}
+
+		if affected, affectedErr := result.RowsAffected(); affectedErr == nil && affected == 0 {
+			duplicateBlobKeys = append(duplicateBlobKeys, encoding.blobKey)
+		}
+	}
+
+	if commitErr := errors.Join(transaction.Commit(), statement.Close()); commitErr != nil {
+		return 0, errors.Join(commitErr, discardBlobs(ctx, p.options, collectBlobKeys(blobKeys)))
+	}
+	p.instrumentation.sent(len(records)-len(duplicateBlobKeys), len(duplicateBlobKeys))
+
+	return len(records) - len(duplicateBlobKeys), discardBlobs(ctx, p.options, collectBlobKeys(duplicateBlobKeys))
+}
+
+func (p *sqliteQueue) ReEncrypt(ctx context.Context) (int, error) {
+	if p.options.KeyProvider == nil {
+		return 0, types.ErrKeyProviderNotConfigured
+	}
+
+	currentKeyID, _, keyErr := p.options.KeyProvider.CurrentKey(ctx)
+	if keyErr != nil {
+		return 0, keyErr
+	}
+
+	selectQuery := fmt.Sprintf(`SELECT id, payload, key_id, blob_key FROM %s 
+		WHERE id > ? AND key_id IS NOT ? ORDER BY id LIMIT ?;`, p.table)
+	updateQuery := fmt.Sprintf(`UPDATE %s SET payload = ?, key_id = ?, blob_key = ? 
+		WHERE id = ? AND key_id IS ? AND blob_key IS ?;`, p.table)
+
+	var (
+		lastID    uint
+		rewritten int
+	)
+	for {
+		rows, queryErr := p.db.QueryContext(ctx, selectQuery, lastID, currentKeyID, reencryptChunkSize)
+		if queryErr != nil {
+			return rewritten, queryErr
+		}
+
+		type row struct {
+			id       uint
+			payload  []byte
+			encoding payloadEncoding
+		}
+		var chunk []row
+		for rows.Next() {
+			var r row
+			if scanErr := rows.Scan(&r.id, &r.payload, &r.encoding.keyID, &r.encoding.blobKey); scanErr != nil {
+				return rewritten, errors.Join(scanErr, rows.Close())
+			}
+			chunk = append(chunk, r)
+		}
+		if rowsErr := errors.Join(rows.Err(), rows.Close()); rowsErr != nil {
+			return rewritten, rowsErr
+		}
+
+		for _, r := range chunk {
+			reencrypted, reencryptErr := reencryptPayload(ctx, p.options, r.payload, r.encoding)
+			if reencryptErr != nil {
+				return rewritten, reencryptErr
+			}
+
+			result, execErr := p.db.ExecContext(ctx, updateQuery, reencrypted.payload, reencrypted.keyID,
+				reencrypted.blobKey, r.id, r.encoding.keyID, r.encoding.blobKey)
+			if execErr != nil {
+				return rewritten, errors.Join(execErr, finishReencrypt(ctx, p.options, r.encoding, reencrypted, false))
+			}
+			affected, affectedErr := result.RowsAffected()
+			if affectedErr != nil {
+				return rewritten, affectedErr
+			}
+			if finishErr := finishReencrypt(ctx, p.options, r.encoding, reencrypted, affected == 1); finishErr != nil {
+				return rewritten, finishErr
+			}
+			rewritten += int(affected)
+			lastID = r.id
+		}
+
+		if len(chunk) < reencryptChunkSize {
+			return rewritten, nil
+		}
+	}
+}
+
+func (p *sqliteQueue) Stats(ctx context.Context) (*types.QueueStats, error) {
+	query := fmt.Sprintf(`SELECT COUNT(*), COALESCE(SUM(CASE WHEN visible_after <= ? THEN 1 ELSE 0 END), 0), 
+		MIN(created_at) FROM %s;`, p.table)
+
+	var (
+		stats    types.QueueStats
+		oldestAt *int64
+	)
+	now := time.Now()
+	if scanErr := p.db.QueryRowContext(ctx, query, now.Unix()).Scan(&stats.Depth, &stats.Visible,
+		&oldestAt); scanErr != nil {
+		return nil, scanErr
+	}
+	if oldestAt != nil {
+		stats.OldestAge = now.Sub(time.Unix(*oldestAt, 0))
+	}
+
+	p.instrumentation.stats(&stats)
+	return &stats, nil
+}
@@ -0,0 +1,597 @@
+package enginetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory returns the engine a test runs against. It is called once per test and may return the same engine
+// every time, since each test works on its own queue and deletes it afterwards.
+type Factory func(t *testing.T) types.Engine
+
+type conformanceTest struct {
+	name string
+	fun  func(t *testing.T, engine types.Engine, queue types.Queue)
+}
+
+var conformanceTests = []conformanceTest{
+	{name: "message_fields", fun: testMessageFields},
+	{name: "priority", fun: testPriority},
+	{name: "priority_aging", fun: testPriorityAging},
+	{name: "retrieval", fun: testRetrieval},
+	{name: "visibility_expiry", fun: testVisibilityExpiry},
+	{name: "change_visibility", fun: testChangeVisibility},
+	{name: "delay", fun: testDelay},
+	{name: "deduplication", fun: testDeduplication},
+	{name: "batch", fun: testBatch},
+	{name: "filters", fun: testFilters},
+	{name: "lifecycle", fun: testLifecycle},
+	{name: "concurrent_consumers", fun: testConcurrentConsumers},
+	{name: "read_write_delete", fun: testReadWriteDelete},
+	{name: "cancellation", fun: testCancellation},
+	{name: "errors", fun: testErrors},
+}
+
+// Run checks that the engine built by factory behaves like the engines of this module.
+func Run(t *testing.T, factory Factory) {
+	for _, test := range conformanceTests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			engine := factory(t)
+			name := "conformance_" + test.name
+			if deleteErr := engine.DeleteQueue(ctx, name); deleteErr != nil {
+				t.Fatal(deleteErr)
+			}
+			queue, createErr := engine.CreateQueue(ctx, name)
+			if createErr != nil {
+				t.Fatal(createErr)
+			}
+			t.Cleanup(func() {
+				assert.NoError(t, engine.DeleteQueue(ctx, name))
+			})
+
+			test.fun(t, engine, queue)
+		})
+	}
+}
+
+func visibleNow() *int64 {
+	return common.Ptr(time.Now().Add(-time.Minute).Unix())
+}
+
+func send(t *testing.T, queue types.Queue, messages ...*types.Message) {
+	t.Helper()
+	for _, message := range messages {
+		if message.VisibleAfter == nil {
+			message.VisibleAfter = visibleNow()
+		}
+	}
+	if sendErr := queue.SendMessageBatch(context.Background(), messages); sendErr != nil {
+		t.Fatal(sendErr)
+	}
+}
+
+func claim(t *testing.T, queue types.Queue, options types.ReceiveMessageOptions) []types.ReceivedMessage {
+	t.Helper()
+	messages, claimErr := queue.ClaimMessages(context.Background(), options)
+	if claimErr != nil {
+		t.Fatal(claimErr)
+	}
+	return messages
+}
+
+// claimWithin polls until a message can be claimed, since engines track visibility in whole seconds.
+func claimWithin(t *testing.T, queue types.Queue, timeout time.Duration,
+	options types.ReceiveMessageOptions) []types.ReceivedMessage {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		if messages := claim(t, queue, options); len(messages) > 0 || time.Now().After(deadline) {
+			return messages
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func importRecords(t *testing.T, queue types.Queue, records []types.ExportRecord) error {
+	t.Helper()
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, record := range records {
+		record.Version = types.ExportFormatVersion
+		if encodeErr := encoder.Encode(record); encodeErr != nil {
+			t.Fatal(encodeErr)
+		}
+	}
+	_, importErr := queue.Import(context.Background(), &buffer)
+	return importErr
+}
+
+func payloads(messages []types.ReceivedMessage) []string {
+	result := make([]string, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, string(message.Payload))
+	}
+	return result
+}
+
+func ids(messages []types.ReceivedMessage) []uint {
+	result := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, message.ID)
+	}
+	return result
+}
+
+func depth(t *testing.T, queue types.Queue) int {
+	t.Helper()
+	stats, statsErr := queue.Stats(context.Background())
+	if statsErr != nil {
+		t.Fatal(statsErr)
+	}
+	return stats.Depth
+}
+
+func testMessageFields(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	sent := time.Now().Unix()
+	send(t, queue, &types.Message{
+		Payload:         []byte("payload"),
+		Priority:        7,
+		DeduplicationID: common.Ptr("fields"),
+		Attributes:      map[string]string{"tenant": "a", "kind": "b"},
+	})
+
+	// when
+	messages := claim(t, queue, types.ReceiveMessageOptions{})
+
+	// then
+	if assert.Len(t, messages, 1) {
+		message := messages[0]
+		assert.Equal(t, "payload", string(message.Payload))
+		assert.Equal(t, uint32(7), message.Priority)
+		assert.Equal(t, common.Ptr("fields"), message.DeduplicationID)
+		assert.Equal(t, map[string]string{"tenant": "a", "kind": "b"}, message.Attributes)
+		assert.Equal(t, uint32(1), message.Retrieval)
+		assert.InDelta(t, sent, message.CreatedAt, 2)
+		assert.NotZero(t, message.ID)
+	}
+}
+
+func testPriority(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	for i := 1; i <= 10; i++ {
+		send(t, queue, &types.Message{
+			Payload:  []byte(strconv.Itoa(10 - i)),
+			Priority: uint32(10 - i),
+		})
+	}
+	send(t, queue, &types.Message{Payload: []byte("9 again"), Priority: 9})
+
+	// when
+	first := claim(t, queue, types.ReceiveMessageOptions{MaxNumberOfMessages: common.Ptr(3)})
+	rest := claim(t, queue, types.ReceiveMessageOptions{})
+
+	// then
+	assert.Equal(t, []string{"9", "9 again", "8"}, payloads(first))
+	assert.Equal(t, []string{"7", "6", "5", "4", "3", "2", "1", "0"}, payloads(rest))
+}
+
+func testPriorityAging(t *testing.T, engine types.Engine, _ types.Queue) {
+	// given
+	ctx := context.Background()
+	queue, openErr := engine.OpenQueue(ctx, "conformance_priority_aging", types.QueueOptions{
+		PriorityAging: common.Ptr(time.Minute),
+	})
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+	importErr := importRecords(t, queue, []types.ExportRecord{
+		{Payload: []byte("new and important"), Priority: 5, CreatedAt: time.Now().Unix()},
+		{Payload: []byte("old"), Priority: 0, CreatedAt: time.Now().Add(-time.Hour).Unix()},
+	})
+
+	// when
+	messages := claim(t, queue, types.ReceiveMessageOptions{})
+
+	// then
+	assert.NoError(t, importErr)
+	assert.Equal(t, []string{"old", "new and important"}, payloads(messages))
+}
+
+func testRetrieval(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	send(t, queue, &types.Message{Payload: []byte("1")})
+
+	// when
+	retrievals := make(chan uint32)
+	go func() {
+		_ = queue.ReceiveMessage(ctx, func(message types.ReceivedMessage) {
+			select {
+			case retrievals <- message.Retrieval:
+			case <-ctx.Done():
+			}
+		}, types.ReceiveMessageOptions{
+			VisibilityTimeout: common.Ptr(50 * time.Millisecond),
+			WaitTime:          common.Ptr(100 * time.Millisecond),
+		})
+	}()
+	var received []uint32
+	for len(received) < 10 {
+		received = append(received, <-retrievals)
+	}
+	cancel()
+
+	// then
+	assert.Equal(t, []uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, received)
+}
+
+func testVisibilityExpiry(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	send(t, queue, &types.Message{Payload: []byte("expiring")})
+	options := types.ReceiveMessageOptions{VisibilityTimeout: common.Ptr(time.Second)}
+
+	// when
+	first := claim(t, queue, options)
+	hidden := claim(t, queue, options)
+	second := claimWithin(t, queue, 5*time.Second, options)
+
+	// then
+	assert.Len(t, first, 1)
+	assert.Empty(t, hidden)
+	if assert.Len(t, second, 1) {
+		assert.Equal(t, first[0].ID, second[0].ID)
+		assert.Equal(t, uint32(2), second[0].Retrieval)
+	}
+}
+
+func testChangeVisibility(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	ctx := context.Background()
+	send(t, queue, &types.Message{Payload: []byte("first")}, &types.Message{Payload: []byte("second")})
+	claimed := claim(t, queue, types.ReceiveMessageOptions{VisibilityTimeout: common.Ptr(time.Hour)})
+
+	// when
+	changeErr := queue.ChangeMessageVisibility(ctx, claimed[0].ID, 0)
+	hideErr := queue.ChangeMessageVisibilityBatch(ctx, ids(claimed[1:]), time.Hour)
+	missingErr := queue.ChangeMessageVisibility(ctx, claimed[1].ID+1000, 0)
+	reclaimed := claim(t, queue, types.ReceiveMessageOptions{})
+
+	// then
+	assert.NoError(t, changeErr)
+	assert.NoError(t, hideErr)
+	assert.NoError(t, missingErr)
+	assert.Equal(t, []string{"first"}, payloads(reclaimed))
+}
+
+func testDelay(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	send(t, queue, &types.Message{
+		Payload:      []byte("delayed"),
+		VisibleAfter: common.Ptr(time.Now().Add(time.Second).Unix()),
+	})
+	stats, statsErr := queue.Stats(context.Background())
+
+	// when
+	early := claim(t, queue, types.ReceiveMessageOptions{})
+	late := claimWithin(t, queue, 5*time.Second, types.ReceiveMessageOptions{})
+
+	// then
+	assert.NoError(t, statsErr)
+	assert.Equal(t, 1, stats.Depth)
+	assert.Equal(t, 0, stats.Visible)
+	assert.Empty(t, early)
+	assert.Equal(t, []string{"delayed"}, payloads(late))
+}
+
+func testDeduplication(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	ctx := context.Background()
+	duplicate := func(payload string) *types.Message {
+		return &types.Message{Payload: []byte(payload), DeduplicationID: common.Ptr("same")}
+	}
+
+	// when
+	send(t, queue, duplicate("first"))
+	send(t, queue, duplicate("second"), duplicate("third"), &types.Message{Payload: []byte("other")})
+	duplicatedDepth := depth(t, queue)
+	messages := claim(t, queue, types.ReceiveMessageOptions{})
+	deleteErr := queue.DeleteMessageBatch(ctx, ids(messages))
+	send(t, queue, duplicate("after delete"))
+	resent := claim(t, queue, types.ReceiveMessageOptions{})
+
+	// then
+	assert.Equal(t, 2, duplicatedDepth)
+	assert.ElementsMatch(t, []string{"first", "other"}, payloads(messages))
+	assert.NoError(t, deleteErr)
+	assert.Equal(t, []string{"after delete"}, payloads(resent))
+}
+
+func testBatch(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	ctx := context.Background()
+	send(t, queue, &types.Message{Payload: []byte("1")}, &types.Message{Payload: []byte("2")},
+		&types.Message{Payload: []byte("3")})
+
+	// when
+	emptyErr := queue.SendMessageBatch(ctx, nil)
+	first := claim(t, queue, types.ReceiveMessageOptions{MaxNumberOfMessages: common.Ptr(2)})
+	second := claim(t, queue, types.ReceiveMessageOptions{MaxNumberOfMessages: common.Ptr(2)})
+	deleteErr := queue.DeleteMessageBatch(ctx, append(ids(first), 0))
+	emptyDeleteErr := queue.DeleteMessageBatch(ctx, nil)
+
+	// then
+	assert.NoError(t, emptyErr)
+	assert.ElementsMatch(t, []string{"1", "2"}, payloads(first))
+	assert.Equal(t, []string{"3"}, payloads(second))
+	assert.NoError(t, deleteErr)
+	assert.NoError(t, emptyDeleteErr)
+	assert.Equal(t, 1, depth(t, queue))
+}
+
+func testFilters(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	ctx := context.Background()
+	send(t, queue,
+		&types.Message{Payload: []byte("low"), Priority: 1, Attributes: map[string]string{"tenant": "a"}},
+		&types.Message{Payload: []byte("high"), Priority: 5, Attributes: map[string]string{"tenant": "a"}},
+		&types.Message{Payload: []byte("other"), Priority: 5, Attributes: map[string]string{"tenant": "b"},
+			DeduplicationID: common.Ptr("other_1")},
+	)
+
+	// when
+	_, emptyErr := queue.DeleteWhere(ctx, types.MessageFilter{})
+	rescheduled, rescheduleErr := queue.RescheduleWhere(ctx, types.MessageFilter{
+		DeduplicationIDPrefix: common.Ptr("other_"),
+	}, time.Hour)
+	deleted, deleteErr := queue.DeleteWhere(ctx, types.MessageFilter{
+		MinPriority: common.Ptr(uint32(2)),
+		Attributes:  map[string]string{"tenant": "a"},
+	})
+	remaining := claim(t, queue, types.ReceiveMessageOptions{})
+	if len(remaining) != 1 {
+		t.Fatalf("expected one message, got %d", len(remaining))
+	}
+	stale, staleErr := queue.DeleteWhere(ctx, types.MessageFilter{
+		ID:        common.Ptr(remaining[0].ID),
+		Retrieval: common.Ptr(remaining[0].Retrieval - 1),
+	})
+	current, currentErr := queue.DeleteWhere(ctx, types.MessageFilter{
+		ID:        common.Ptr(remaining[0].ID),
+		Retrieval: common.Ptr(remaining[0].Retrieval),
+	})
+
+	// then
+	assert.ErrorIs(t, emptyErr, types.ErrInvalidMessageFilter)
+	assert.NoError(t, rescheduleErr)
+	assert.Equal(t, 1, rescheduled)
+	assert.NoError(t, deleteErr)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, []string{"low"}, payloads(remaining))
+	assert.NoError(t, staleErr)
+	assert.Equal(t, 0, stale)
+	assert.NoError(t, currentErr)
+	assert.Equal(t, 1, current)
+}
+
+func testLifecycle(t *testing.T, engine types.Engine, queue types.Queue) {
+	// given
+	ctx := context.Background()
+	name := "conformance_lifecycle"
+	send(t, queue, &types.Message{Payload: []byte("kept")})
+
+	// when
+	_, recreateErr := engine.CreateQueue(ctx, name)
+	recreatedDepth := depth(t, queue)
+	listed, listErr := engine.ListQueues(ctx)
+	migrateErr := engine.MigrateQueue(ctx, name)
+	purgeErr := engine.PurgeQueue(ctx, name)
+	purgedDepth := depth(t, queue)
+	deleteErr := engine.DeleteQueue(ctx, name)
+	remaining, remainingErr := engine.ListQueues(ctx)
+	_, openErr := engine.OpenQueue(ctx, name)
+	deleteAgainErr := engine.DeleteQueue(ctx, name)
+
+	// then
+	assert.NoError(t, recreateErr)
+	assert.Equal(t, 1, recreatedDepth)
+	assert.NoError(t, listErr)
+	assert.Contains(t, listed, name)
+	assert.NoError(t, migrateErr)
+	assert.NoError(t, purgeErr)
+	assert.Equal(t, 0, purgedDepth)
+	assert.NoError(t, deleteErr)
+	assert.NoError(t, remainingErr)
+	assert.NotContains(t, remaining, name)
+	assert.ErrorIs(t, openErr, types.ErrQueueNotFound)
+	assert.NoError(t, deleteAgainErr)
+	assert.True(t, sort.StringsAreSorted(listed))
+}
+
+func testConcurrentConsumers(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	const count = 200
+	messages := make([]*types.Message, 0, count)
+	for i := 0; i < count; i++ {
+		messages = append(messages, &types.Message{Payload: []byte(strconv.Itoa(i))})
+	}
+	send(t, queue, messages...)
+
+	// when
+	var (
+		waitGroup sync.WaitGroup
+		mutex     sync.Mutex
+		claimErrs []error
+	)
+	claimed := map[uint]int{}
+	for i := 0; i < 5; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for {
+				batch, claimErr := queue.ClaimMessages(context.Background(), types.ReceiveMessageOptions{
+					MaxNumberOfMessages: common.Ptr(7),
+					VisibilityTimeout:   common.Ptr(time.Hour),
+				})
+
+				mutex.Lock()
+				claimErrs = append(claimErrs, claimErr)
+				for _, message := range batch {
+					claimed[message.ID]++
+				}
+				mutex.Unlock()
+
+				if claimErr != nil || len(batch) == 0 {
+					return
+				}
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	// then
+	assert.NoError(t, errors.Join(claimErrs...))
+	assert.Len(t, claimed, count)
+	for id, times := range claimed {
+		assert.Equal(t, 1, times, "message %d was claimed more than once", id)
+	}
+}
+
+func testReadWriteDelete(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	const (
+		senderCount   = 5
+		receiverCount = 5
+		limit         = 5000
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// when
+	var (
+		waitGroup sync.WaitGroup
+		mutex     sync.Mutex
+		received  = map[string]int{}
+		finished  = make(chan struct{})
+	)
+	for i := 0; i < receiverCount; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			_ = queue.ReceiveMessage(ctx, func(message types.ReceivedMessage) {
+				if deleteErr := queue.DeleteMessage(ctx, message.ID); ctx.Err() == nil {
+					assert.NoError(t, deleteErr)
+				}
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				payload := string(message.Payload)
+				if received[payload]++; received[payload] == 1 && len(received) == limit {
+					close(finished)
+				}
+			}, types.ReceiveMessageOptions{MaxNumberOfMessages: common.Ptr(1)})
+		}()
+	}
+	for i := 0; i < senderCount; i++ {
+		go func() {
+			for j := 0; j < limit/senderCount; j++ {
+				assert.NoError(t, queue.SendMessage(ctx, &types.Message{
+					Payload: []byte(fmt.Sprintf("%d-%d", i, j)),
+				}))
+			}
+		}()
+	}
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Minute):
+		t.Error("timed out waiting for messages")
+	}
+	cancel()
+	waitGroup.Wait()
+
+	// then
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Len(t, received, limit)
+}
+
+func testCancellation(t *testing.T, _ types.Engine, queue types.Queue) {
+	// given
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
+	// when
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- queue.ReceiveMessage(ctx, func(types.ReceivedMessage) {}, types.ReceiveMessageOptions{
+			WaitTime: common.Ptr(100 * time.Millisecond),
+		})
+	}()
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	_, claimErr := queue.ClaimMessages(canceled, types.ReceiveMessageOptions{})
+
+	// then
+	select {
+	case receiveErr := <-stopped:
+		assert.ErrorIs(t, receiveErr, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiving did not stop after cancellation")
+	}
+	assert.ErrorIs(t, claimErr, context.Canceled)
+}
+
+func testErrors(t *testing.T, engine types.Engine, queue types.Queue) {
+	// given
+	ctx := context.Background()
+	invalid := "Invalid-Name"
+
+	// when
+	_, createErr := engine.CreateQueue(ctx, invalid)
+	_, openInvalidErr := engine.OpenQueue(ctx, invalid)
+	deleteErr := engine.DeleteQueue(ctx, invalid)
+	purgeErr := engine.PurgeQueue(ctx, invalid)
+	migrateErr := engine.MigrateQueue(ctx, invalid)
+	_, reservedErr := engine.CreateQueue(ctx, "dbqueue_registry")
+	_, missingErr := engine.OpenQueue(ctx, "conformance_missing")
+	migrateMissingErr := engine.MigrateQueue(ctx, "conformance_missing")
+	purgeMissingErr := engine.PurgeQueue(ctx, "conformance_missing")
+	deleteQueueMissingErr := engine.DeleteQueue(ctx, "conformance_missing")
+	_, agingErr := engine.OpenQueue(ctx, "conformance_errors", types.QueueOptions{
+		PriorityAging: common.Ptr(time.Millisecond),
+	})
+	_, rateErr := engine.RateLimiter(ctx, "conformance_errors", 0, 1)
+	_, reencryptErr := queue.ReEncrypt(ctx)
+	deleteMissingErr := queue.DeleteMessage(ctx, 1000000)
+
+	// then
+	assert.ErrorIs(t, createErr, types.ErrInvalidQueueName)
+	assert.ErrorIs(t, openInvalidErr, types.ErrInvalidQueueName)
+	assert.ErrorIs(t, deleteErr, types.ErrInvalidQueueName)
+	assert.ErrorIs(t, purgeErr, types.ErrInvalidQueueName)
+	assert.ErrorIs(t, migrateErr, types.ErrInvalidQueueName)
+	assert.ErrorIs(t, reservedErr, types.ErrInvalidQueueName)
+	assert.ErrorIs(t, missingErr, types.ErrQueueNotFound)
+	assert.ErrorIs(t, migrateMissingErr, types.ErrQueueNotFound)
+	assert.ErrorIs(t, purgeMissingErr, types.ErrQueueNotFound)
+	assert.NoError(t, deleteQueueMissingErr)
+	assert.ErrorIs(t, agingErr, types.ErrInvalidPriorityAging)
+	assert.ErrorIs(t, rateErr, types.ErrInvalidRateLimit)
+	assert.ErrorIs(t, reencryptErr, types.ErrKeyProviderNotConfigured)
+	assert.NoError(t, deleteMissingErr)
+}
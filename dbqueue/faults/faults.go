@@ -0,0 +1,410 @@
+package faults
+
+import (
+	"context"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+type Operation string
+
+const (
+	OperationPing             Operation = "ping"
+	OperationOpenQueue        Operation = "open_queue"
+	OperationCreateQueue      Operation = "create_queue"
+	OperationDeleteQueue      Operation = "delete_queue"
+	OperationPurgeQueue       Operation = "purge_queue"
+	OperationSend             Operation = "send"
+	OperationClaim            Operation = "claim"
+	OperationDelete           Operation = "delete"
+	OperationChangeVisibility Operation = "change_visibility"
+	OperationDeleteWhere      Operation = "delete_where"
+	OperationRescheduleWhere  Operation = "reschedule_where"
+	OperationExport           Operation = "export"
+	OperationImport           Operation = "import"
+	OperationReEncrypt        Operation = "re_encrypt"
+	OperationStats            Operation = "stats"
+)
+
+// Rule describes a fault. Latency is waited first, then Err is returned without running the operation, or after
+// running it with ApplyThenFail, like a commit whose acknowledgement was lost. A claim that fails after running
+// returns no messages, which stay claimed until their visibility timeout expires. DropCommit skips write operations
+// while reporting success. It does not apply to claims; Duplicate models their lost commits instead, delivering
+// every claimed message again on the next claim of its queue.
+type Rule struct {
+	Operations    []Operation
+	Queues        []string
+	Probability   *float64
+	Times         *int
+	Latency       *time.Duration
+	Err           error
+	ApplyThenFail bool
+	DropCommit    bool
+	Duplicate     bool
+}
+
+type Options struct {
+	Seed    *int64
+	Rules   []Rule
+	OnFault func(operation Operation, queue string, rule int)
+}
+
+func (o *Options) Defaults() *Options {
+	if o.Seed == nil {
+		o.Seed = common.Ptr[int64](1)
+	}
+	o.Rules = slices.Clone(o.Rules)
+	for i := range o.Rules {
+		if o.Rules[i].Probability == nil {
+			o.Rules[i].Probability = common.Ptr(1.0)
+		}
+		if o.Rules[i].Times == nil {
+			o.Rules[i].Times = common.Ptr(0)
+		}
+	}
+	return o
+}
+
+type fault struct {
+	dropCommit bool
+	duplicate  bool
+	afterErr   error
+}
+
+// after returns the error of an operation that ran, replaced by the injected one when the operation succeeded.
+func (f fault) after(err error) error {
+	if err != nil {
+		return err
+	}
+	return f.afterErr
+}
+
+// injector picks the faults of both the engine and its queues from one random source, so a run that makes the
+// same calls in the same order sees the same faults. It also holds the duplicates waiting for the next claim.
+type injector struct {
+	mutex        sync.Mutex
+	random       *rand.Rand
+	fired        []int
+	redeliveries map[string][]types.ReceivedMessage
+	options      *Options
+}
+
+type faultEngine struct {
+	types.Engine
+	injector *injector
+}
+
+type faultQueue struct {
+	types.Queue
+	name     string
+	injector *injector
+}
+
+func WrapEngine(engine types.Engine, options ...Options) types.Engine {
+	faultOptions := common.First(options)
+	return &faultEngine{
+		Engine:   engine,
+		injector: newInjector(faultOptions.Defaults()),
+	}
+}
+
+func WrapQueue(queue types.Queue, name string, options ...Options) types.Queue {
+	faultOptions := common.First(options)
+	return &faultQueue{
+		Queue:    queue,
+		name:     name,
+		injector: newInjector(faultOptions.Defaults()),
+	}
+}
+
+func newInjector(options *Options) *injector {
+	return &injector{
+		random:       rand.New(rand.NewSource(*options.Seed)),
+		fired:        make([]int, len(options.Rules)),
+		redeliveries: map[string][]types.ReceivedMessage{},
+		options:      options,
+	}
+}
+
+// inject applies the first rule that matches the operation and fires. Rules that do not match do not consume
+// random numbers, so adding a rule for one operation leaves the faults of the others unchanged.
+func (p *injector) inject(ctx context.Context, operation Operation, queue string) (fault, error) {
+	p.mutex.Lock()
+	index := -1
+	for i, rule := range p.options.Rules {
+		if (len(rule.Operations) > 0 && !slices.Contains(rule.Operations, operation)) ||
+			(len(rule.Queues) > 0 && !slices.Contains(rule.Queues, queue)) ||
+			(*rule.Times > 0 && p.fired[i] >= *rule.Times) {
+			continue
+		}
+		if p.random.Float64() < *rule.Probability {
+			p.fired[i]++
+			index = i
+			break
+		}
+	}
+	p.mutex.Unlock()
+
+	if index < 0 {
+		return fault{}, nil
+	}
+	rule := p.options.Rules[index]
+	if p.options.OnFault != nil {
+		p.options.OnFault(operation, queue, index)
+	}
+
+	if rule.Latency != nil {
+		select {
+		case <-ctx.Done():
+			return fault{}, ctx.Err()
+		case <-time.After(*rule.Latency):
+		}
+	}
+	injected := fault{dropCommit: rule.DropCommit, duplicate: rule.Duplicate}
+	if rule.Err != nil {
+		injectErr := fmt.Errorf("%w: %s on %q", rule.Err, operation, queue)
+		if !rule.ApplyThenFail {
+			return fault{}, injectErr
+		}
+		injected.afterErr = injectErr
+	}
+	return injected, nil
+}
+
+// redeliver queues copies of claimed messages for the next claim, received once more.
+func (p *injector) redeliver(queue string, messages []types.ReceivedMessage) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, message := range messages {
+		message.Retrieval++
+		p.redeliveries[queue] = append(p.redeliveries[queue], message)
+	}
+}
+
+// redelivered takes up to limit queued duplicates of a queue, all of them when limit is zero.
+func (p *injector) redelivered(queue string, limit int) []types.ReceivedMessage {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pending := p.redeliveries[queue]
+	if limit == 0 || limit > len(pending) {
+		limit = len(pending)
+	}
+	p.redeliveries[queue] = pending[limit:]
+	return pending[:limit:limit]
+}
+
+// restore puts duplicates back when the claim that took them failed.
+func (p *injector) restore(queue string, messages []types.ReceivedMessage) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.redeliveries[queue] = append(messages, p.redeliveries[queue]...)
+}
+
+func (p *faultEngine) Ping(ctx context.Context) error {
+	injected, injectErr := p.injector.inject(ctx, OperationPing, "")
+	if injectErr != nil {
+		return injectErr
+	}
+	return injected.after(p.Engine.Ping(ctx))
+}
+
+func (p *faultEngine) OpenQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	injected, injectErr := p.injector.inject(ctx, OperationOpenQueue, name)
+	if injectErr != nil {
+		return nil, injectErr
+	}
+
+	queue, openErr := p.Engine.OpenQueue(ctx, name, options...)
+	if openErr = injected.after(openErr); openErr != nil {
+		return nil, openErr
+	}
+	return &faultQueue{Queue: queue, name: name, injector: p.injector}, nil
+}
+
+func (p *faultEngine) CreateQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	injected, injectErr := p.injector.inject(ctx, OperationCreateQueue, name)
+	if injectErr != nil {
+		return nil, injectErr
+	}
+
+	queue, createErr := p.Engine.CreateQueue(ctx, name, options...)
+	if createErr = injected.after(createErr); createErr != nil {
+		return nil, createErr
+	}
+	return &faultQueue{Queue: queue, name: name, injector: p.injector}, nil
+}
+
+func (p *faultEngine) DeleteQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	injected, injectErr := p.injector.inject(ctx, OperationDeleteQueue, name)
+	if injectErr != nil || injected.dropCommit {
+		return injectErr
+	}
+	return injected.after(p.Engine.DeleteQueue(ctx, name, options...))
+}
+
+func (p *faultEngine) PurgeQueue(ctx context.Context, name string, options ...types.QueueOptions) error {
+	injected, injectErr := p.injector.inject(ctx, OperationPurgeQueue, name)
+	if injectErr != nil || injected.dropCommit {
+		return injectErr
+	}
+	return injected.after(p.Engine.PurgeQueue(ctx, name, options...))
+}
+
+// ReceiveMessage polls through ClaimMessages like the SQL engines do, so claim faults also reach receivers.
+func (p *faultQueue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	opts := options.Defaults()
+	for {
+		messages, claimErr := p.ClaimMessages(ctx, *opts)
+		if claimErr != nil {
+			return claimErr
+		}
+
+		for _, message := range messages {
+			fun(message)
+		}
+
+		if len(messages) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(opts.PollInterval()):
+			}
+		}
+	}
+}
+
+func (p *faultQueue) ClaimMessages(ctx context.Context,
+	options types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	injected, injectErr := p.injector.inject(ctx, OperationClaim, p.name)
+	if injectErr != nil {
+		return nil, injectErr
+	}
+
+	limit := *options.Defaults().MaxNumberOfMessages
+	redelivered := p.injector.redelivered(p.name, limit)
+	var messages []types.ReceivedMessage
+	if limit == 0 || len(redelivered) < limit {
+		if limit > 0 {
+			options.MaxNumberOfMessages = common.Ptr(limit - len(redelivered))
+		}
+		claimed, claimErr := p.Queue.ClaimMessages(ctx, options)
+		if claimErr != nil {
+			p.injector.restore(p.name, redelivered)
+			return nil, claimErr
+		}
+		messages = claimed
+	}
+
+	if injected.afterErr != nil {
+		p.injector.restore(p.name, redelivered)
+		return nil, injected.afterErr
+	}
+	if injected.duplicate {
+		p.injector.redeliver(p.name, messages)
+	}
+	return append(redelivered, messages...), nil
+}
+
+func (p *faultQueue) SendMessage(ctx context.Context, message *types.Message) error {
+	return p.SendMessageBatch(ctx, []*types.Message{message})
+}
+
+func (p *faultQueue) SendMessageBatch(ctx context.Context, messages []*types.Message) error {
+	injected, injectErr := p.injector.inject(ctx, OperationSend, p.name)
+	if injectErr != nil || injected.dropCommit {
+		return injectErr
+	}
+	return injected.after(p.Queue.SendMessageBatch(ctx, messages))
+}
+
+func (p *faultQueue) DeleteMessage(ctx context.Context, id uint) error {
+	return p.DeleteMessageBatch(ctx, []uint{id})
+}
+
+func (p *faultQueue) DeleteMessageBatch(ctx context.Context, ids []uint) error {
+	injected, injectErr := p.injector.inject(ctx, OperationDelete, p.name)
+	if injectErr != nil || injected.dropCommit {
+		return injectErr
+	}
+	return injected.after(p.Queue.DeleteMessageBatch(ctx, ids))
+}
+
+func (p *faultQueue) ChangeMessageVisibility(ctx context.Context, id uint, visibilityTimeout time.Duration) error {
+	return p.ChangeMessageVisibilityBatch(ctx, []uint{id}, visibilityTimeout)
+}
+
+func (p *faultQueue) ChangeMessageVisibilityBatch(ctx context.Context, ids []uint,
+	visibilityTimeout time.Duration) error {
+	injected, injectErr := p.injector.inject(ctx, OperationChangeVisibility, p.name)
+	if injectErr != nil || injected.dropCommit {
+		return injectErr
+	}
+	return injected.after(p.Queue.ChangeMessageVisibilityBatch(ctx, ids, visibilityTimeout))
+}
+
+func (p *faultQueue) DeleteWhere(ctx context.Context, filter types.MessageFilter) (int, error) {
+	injected, injectErr := p.injector.inject(ctx, OperationDeleteWhere, p.name)
+	if injectErr != nil || injected.dropCommit {
+		return 0, injectErr
+	}
+	deleted, deleteErr := p.Queue.DeleteWhere(ctx, filter)
+	return deleted, injected.after(deleteErr)
+}
+
+func (p *faultQueue) RescheduleWhere(ctx context.Context, filter types.MessageFilter,
+	visibilityTimeout time.Duration) (int, error) {
+	injected, injectErr := p.injector.inject(ctx, OperationRescheduleWhere, p.name)
+	if injectErr != nil || injected.dropCommit {
+		return 0, injectErr
+	}
+	rescheduled, rescheduleErr := p.Queue.RescheduleWhere(ctx, filter, visibilityTimeout)
+	return rescheduled, injected.after(rescheduleErr)
+}
+
+func (p *faultQueue) Export(ctx context.Context, w io.Writer, options ...types.ExportOptions) (int, error) {
+	injected, injectErr := p.injector.inject(ctx, OperationExport, p.name)
+	if injectErr != nil {
+		return 0, injectErr
+	}
+	exported, exportErr := p.Queue.Export(ctx, w, options...)
+	return exported, injected.after(exportErr)
+}
+
+func (p *faultQueue) Import(ctx context.Context, r io.Reader, options ...types.ImportOptions) (int, error) {
+	injected, injectErr := p.injector.inject(ctx, OperationImport, p.name)
+	if injectErr != nil || injected.dropCommit {
+		return 0, injectErr
+	}
+	imported, importErr := p.Queue.Import(ctx, r, options...)
+	return imported, injected.after(importErr)
+}
+
+func (p *faultQueue) ReEncrypt(ctx context.Context) (int, error) {
+	injected, injectErr := p.injector.inject(ctx, OperationReEncrypt, p.name)
+	if injectErr != nil || injected.dropCommit {
+		return 0, injectErr
+	}
+	reencrypted, reencryptErr := p.Queue.ReEncrypt(ctx)
+	return reencrypted, injected.after(reencryptErr)
+}
+
+func (p *faultQueue) Stats(ctx context.Context) (*types.QueueStats, error) {
+	injected, injectErr := p.injector.inject(ctx, OperationStats, p.name)
+	if injectErr != nil {
+		return nil, injectErr
+	}
+	stats, statsErr := p.Queue.Stats(ctx)
+	return stats, injected.after(statsErr)
+}
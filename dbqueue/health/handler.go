@@ -0,0 +1,84 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"net/http"
+	"time"
+)
+
+type Options struct {
+	Timeout *time.Duration
+}
+
+func (o *Options) Defaults() *Options {
+	if o.Timeout == nil {
+		o.Timeout = common.Ptr(2 * time.Second)
+	}
+	return o
+}
+
+type report struct {
+	Status    string        `json:"status"`
+	LatencyMs float64       `json:"latency_ms,omitempty"`
+	Registry  bool          `json:"registry"`
+	Queues    []queueReport `json:"queues,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type queueReport struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	Error   string `json:"error,omitempty"`
+}
+
+func LivenessHandler(engine types.Engine, options ...Options) http.Handler {
+	return handler(options, func(ctx context.Context) (*types.Health, error) {
+		started := time.Now()
+		if pingErr := engine.Ping(ctx); pingErr != nil {
+			return nil, pingErr
+		}
+		return &types.Health{Latency: time.Since(started)}, nil
+	})
+}
+
+func ReadinessHandler(engine types.Engine, options ...Options) http.Handler {
+	return handler(options, engine.Health)
+}
+
+func handler(options []Options, check func(ctx context.Context) (*types.Health, error)) http.Handler {
+	handlerOptions := common.First(options)
+	handlerOptions.Defaults()
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx, cancel := context.WithTimeout(request.Context(), *handlerOptions.Timeout)
+		defer cancel()
+
+		health, checkErr := check(ctx)
+		response := report{Status: "ok"}
+		if health != nil {
+			response.LatencyMs = float64(health.Latency.Microseconds()) / 1000
+			response.Registry = health.Registry
+			for _, queue := range health.Queues {
+				queueResponse := queueReport{Name: queue.Name, Version: queue.Version}
+				if queue.Err != nil {
+					queueResponse.Error = queue.Err.Error()
+				}
+				response.Queues = append(response.Queues, queueResponse)
+			}
+		}
+
+		status := http.StatusOK
+		if checkErr != nil {
+			status = http.StatusServiceUnavailable
+			response.Status = "unavailable"
+			response.Error = checkErr.Error()
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(status)
+		_ = json.NewEncoder(writer).Encode(response)
+	})
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"time"
+)
+
+type Expvar struct {
+	mu   sync.Mutex
+	root *expvar.Map
+}
+
+type histogram struct {
+	mu    sync.Mutex
+	count int64
+	sum   time.Duration
+	max   time.Duration
+}
+
+var publishMu sync.Mutex
+
+// NewExpvar publishes the metrics under name. Engines built with the same name share one map, so it can be called
+// once per engine.
+func NewExpvar(name string) *Expvar {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	if existing, published := expvar.Get(name).(*expvar.Map); published {
+		return NewExpvarFromMap(existing)
+	}
+	return NewExpvarFromMap(expvar.NewMap(name))
+}
+
+// NewExpvarFromMap records the metrics into a map the caller published or keeps unpublished.
+func NewExpvarFromMap(root *expvar.Map) *Expvar {
+	return &Expvar{
+		root: root,
+	}
+}
+
+func (p *Expvar) MessagesSent(queue string, count int) {
+	p.queue(queue).Add("sent", int64(count))
+}
+
+func (p *Expvar) MessagesReceived(queue string, count int) {
+	p.queue(queue).Add("received", int64(count))
+}
+
+func (p *Expvar) MessagesDeleted(queue string, count int) {
+	p.queue(queue).Add("deleted", int64(count))
+}
+
+func (p *Expvar) MessagesDeduplicated(queue string, count int) {
+	p.queue(queue).Add("deduplicated", int64(count))
+}
+
+func (p *Expvar) MessagesRetried(queue string, count int) {
+	p.queue(queue).Add("retried", int64(count))
+}
+
+// MessagesDeadLettered is part of types.Metrics for dead-letter queues. No engine reports it yet.
+func (p *Expvar) MessagesDeadLettered(queue string, count int) {
+	p.queue(queue).Add("dead_lettered", int64(count))
+}
+
+func (p *Expvar) HandlerDuration(queue string, duration time.Duration) {
+	p.histogram(queue, "handler_duration").observe(duration)
+}
+
+func (p *Expvar) EndToEndLatency(queue string, latency time.Duration) {
+	p.histogram(queue, "end_to_end_latency").observe(latency)
+}
+
+func (p *Expvar) ClaimDuration(queue string, duration time.Duration) {
+	p.histogram(queue, "claim_duration").observe(duration)
+}
+
+func (p *Expvar) QueueDepth(queue string, depth int) {
+	p.gauge(queue, "depth").Set(int64(depth))
+}
+
+func (p *Expvar) OldestMessageAge(queue string, age time.Duration) {
+	p.gauge(queue, "oldest_age_ms").Set(age.Milliseconds())
+}
+
+func (p *Expvar) queue(queue string) *expvar.Map {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return load(p.root, queue, func() *expvar.Map { return new(expvar.Map).Init() })
+}
+
+func (p *Expvar) gauge(queue, name string) *expvar.Int {
+	parent := p.queue(queue)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return load(parent, name, func() *expvar.Int { return new(expvar.Int) })
+}
+
+func (p *Expvar) histogram(queue, name string) *histogram {
+	parent := p.queue(queue)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return load(parent, name, func() *histogram { return new(histogram) })
+}
+
+func load[T expvar.Var](parent *expvar.Map, name string, create func() T) T {
+	if existing := parent.Get(name); existing != nil {
+		return existing.(T)
+	}
+	created := create()
+	parent.Set(name, created)
+	return created
+}
+
+func (h *histogram) observe(duration time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count++
+	h.sum += duration
+	h.max = max(h.max, duration)
+}
+
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	encoded, _ := json.Marshal(map[string]float64{
+		"count":  float64(h.count),
+		"sum_ms": float64(h.sum.Microseconds()) / 1000,
+		"max_ms": float64(h.max.Microseconds()) / 1000,
+	})
+	return string(encoded)
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"context"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"time"
+)
+
+// Sample calls Stats on every queue once per interval until ctx is done or Stats fails, so the engines report the
+// QueueDepth and OldestMessageAge gauges without the caller polling.
+func Sample(ctx context.Context, interval time.Duration, queues ...types.Queue) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, queue := range queues {
+			if _, statsErr := queue.Stats(ctx); statsErr != nil {
+				return statsErr
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
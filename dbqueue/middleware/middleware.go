@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"maps"
+	"sync"
+	"time"
+)
+
+type SendFunc func(ctx context.Context, messages []*types.Message) error
+
+type SendMiddleware func(next SendFunc) SendFunc
+
+type Handler func(ctx context.Context, message types.ReceivedMessage) error
+
+type HandlerMiddleware func(next Handler) Handler
+
+type Options struct {
+	Send         []SendMiddleware
+	Handler      []HandlerMiddleware
+	RetryDelay   *time.Duration
+	ErrorHandler func(ctx context.Context, message types.ReceivedMessage, err error)
+}
+
+func (o *Options) Defaults() *Options {
+	if o.RetryDelay == nil {
+		o.RetryDelay = common.Ptr(time.Duration(0))
+	}
+	if o.ErrorHandler == nil {
+		o.ErrorHandler = func(context.Context, types.ReceivedMessage, error) {}
+	}
+	return o
+}
+
+type Queue struct {
+	types.Queue
+	send    SendFunc
+	options *Options
+}
+
+// abandonedError is returned by Timeout when it gives up on a handler that is still running.
+type abandonedError struct {
+	err      error
+	finished <-chan struct{}
+}
+
+func (e *abandonedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *abandonedError) Unwrap() error {
+	return e.err
+}
+
+func Wrap(queue types.Queue, options ...Options) *Queue {
+	middlewareOptions := common.First(options)
+	middlewareOptions.Defaults()
+
+	send := SendFunc(queue.SendMessageBatch)
+	for i := len(middlewareOptions.Send) - 1; i >= 0; i-- {
+		send = middlewareOptions.Send[i](send)
+	}
+
+	return &Queue{
+		Queue:   queue,
+		send:    send,
+		options: &middlewareOptions,
+	}
+}
+
+func (p *Queue) SendMessage(ctx context.Context, message *types.Message) error {
+	return p.SendMessageBatch(ctx, []*types.Message{message})
+}
+
+func (p *Queue) SendMessageBatch(ctx context.Context, messages []*types.Message) error {
+	return p.send(ctx, messages)
+}
+
+func (p *Queue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	return p.Consume(ctx, func(_ context.Context, message types.ReceivedMessage) error {
+		fun(message)
+		return nil
+	}, options)
+}
+
+// Consume delivers messages to handler and releases a message after RetryDelay when its handler fails. A message
+// whose handler was abandoned by Timeout is released only once that handler has returned. Until then its visibility
+// timeout is extended every half timeout, so no other consumer processes it meanwhile. Its ErrorHandler call then
+// comes from another goroutine, and Consume waits for these before it returns.
+func (p *Queue) Consume(ctx context.Context, handler Handler, options types.ReceiveMessageOptions) error {
+	options.Defaults()
+	for i := len(p.options.Handler) - 1; i >= 0; i-- {
+		handler = p.options.Handler[i](handler)
+	}
+
+	var abandonedHandlers sync.WaitGroup
+	defer abandonedHandlers.Wait()
+
+	return p.Queue.ReceiveMessage(ctx, func(message types.ReceivedMessage) {
+		handleErr := handler(ctx, message)
+		if handleErr == nil {
+			return
+		}
+
+		var abandoned *abandonedError
+		if !errors.As(handleErr, &abandoned) {
+			p.release(ctx, message, handleErr)
+			return
+		}
+
+		abandonedHandlers.Add(1)
+		go func() {
+			defer abandonedHandlers.Done()
+			holdCtx := context.WithoutCancel(ctx)
+			holdErr := p.hold(holdCtx, message, abandoned.finished, *options.VisibilityTimeout)
+			p.release(holdCtx, message, errors.Join(handleErr, holdErr))
+		}()
+	}, options)
+}
+
+// hold keeps the message hidden until finished is closed.
+func (p *Queue) hold(ctx context.Context, message types.ReceivedMessage, finished <-chan struct{},
+	visibility time.Duration) error {
+	ticker := time.NewTicker(max(visibility/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		if changeErr := p.Queue.ChangeMessageVisibility(ctx, message.ID, visibility); changeErr != nil {
+			<-finished
+			return changeErr
+		}
+		select {
+		case <-finished:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Queue) release(ctx context.Context, message types.ReceivedMessage, handleErr error) {
+	releaseErr := p.Queue.ChangeMessageVisibility(ctx, message.ID, *p.options.RetryDelay)
+	p.options.ErrorHandler(ctx, message, errors.Join(handleErr, releaseErr))
+}
+
+func Recover() HandlerMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, message types.ReceivedMessage) (err error) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					err = fmt.Errorf("%w: %v", types.ErrHandlerPanicked, recovered)
+				}
+			}()
+			return next(ctx, message)
+		}
+	}
+}
+
+// Timeout cancels the handler context after timeout and stops waiting for the handler. The handler keeps running
+// until it returns, so it should respect ctx; Consume keeps its message hidden until then.
+func Timeout(timeout time.Duration) HandlerMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, message types.ReceivedMessage) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			var (
+				handleErr error
+				recovered any
+			)
+			finished := make(chan struct{})
+			go func() {
+				defer close(finished)
+				defer func() {
+					recovered = recover()
+				}()
+				handleErr = next(ctx, message)
+			}()
+
+			select {
+			case <-finished:
+				if recovered != nil {
+					panic(recovered)
+				}
+				return handleErr
+			case <-ctx.Done():
+				return &abandonedError{err: ctx.Err(), finished: finished}
+			}
+		}
+	}
+}
+
+func Stamp(attributes map[string]string) SendMiddleware {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, messages []*types.Message) error {
+			stamped := make([]*types.Message, len(messages))
+			for i, message := range messages {
+				stampedMessage := *message
+				stampedMessage.Attributes = make(map[string]string, len(attributes)+len(message.Attributes))
+				maps.Copy(stampedMessage.Attributes, attributes)
+				maps.Copy(stampedMessage.Attributes, message.Attributes)
+				stamped[i] = &stampedMessage
+			}
+			return next(ctx, stamped)
+		}
+	}
+}
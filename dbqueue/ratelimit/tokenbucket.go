@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"sync"
+	"time"
+)
+
+type TokenBucket struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	tokens    float64
+	updatedAt time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) (*TokenBucket, error) {
+	if rate <= 0 || burst < 1 {
+		return nil, fmt.Errorf("%w: rate %v, burst %d", types.ErrInvalidRateLimit, rate, burst)
+	}
+	return &TokenBucket{
+		rate:      rate,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		updatedAt: time.Now(),
+	}, nil
+}
+
+func (p *TokenBucket) Acquire(ctx context.Context, max int) (int, error) {
+	for {
+		granted, wait := p.take(max)
+		if granted > 0 {
+			return granted, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (p *TokenBucket) Release(_ context.Context, count int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.refill(time.Now())
+	p.tokens = min(p.burst, p.tokens+float64(count))
+	return nil
+}
+
+func (p *TokenBucket) take(max int) (int, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.refill(time.Now())
+	if p.tokens < 1 {
+		return 0, time.Duration((1 - p.tokens) / p.rate * float64(time.Second))
+	}
+
+	granted := int(p.tokens)
+	if max > 0 {
+		granted = min(granted, max)
+	}
+	p.tokens -= float64(granted)
+	return granted, 0
+}
+
+func (p *TokenBucket) refill(now time.Time) {
+	p.tokens = min(p.burst, p.tokens+now.Sub(p.updatedAt).Seconds()*p.rate)
+	p.updatedAt = now
+}
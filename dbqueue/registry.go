@@ -0,0 +1,97 @@
+package dbqueue
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/engines"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type Factory func(ctx context.Context, dbURL *url.URL, options ...types.EngineOptions) (types.Engine, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+func init() {
+	Register("postgres", openPostgreSQLURL)
+	Register("postgresql", openPostgreSQLURL)
+	Register("mysql", openMySQLURL)
+	Register("sqlite", openSQLiteURL)
+	Register("sqlite3", openSQLiteURL)
+	Register("memory", openMemoryURL)
+}
+
+func Register(scheme string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if factory == nil {
+		panic("dbqueue: Register factory is nil")
+	}
+	if _, exists := factories[scheme]; exists {
+		panic("dbqueue: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// unregister removes a scheme again, so tests can register theirs once per run.
+func unregister(scheme string) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	delete(factories, strings.ToLower(scheme))
+}
+
+func Open(ctx context.Context, rawURL string, options ...types.EngineOptions) (types.Engine, error) {
+	parsed, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	factoriesMu.RLock()
+	factory, exists := factories[strings.ToLower(parsed.Scheme)]
+	factoriesMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %q", types.ErrDatabaseNotSupported, parsed.Scheme)
+	}
+	return factory(ctx, parsed, options...)
+}
+
+func openPostgreSQLURL(ctx context.Context, dbURL *url.URL, options ...types.EngineOptions) (types.Engine, error) {
+	return engines.NewPostgreSQLEngine(ctx, dbURL.String(), options...)
+}
+
+func openMySQLURL(ctx context.Context, dbURL *url.URL, options ...types.EngineOptions) (types.Engine, error) {
+	config, parseErr := mysql.ParseDSN(fmt.Sprintf("/%s?%s", strings.TrimPrefix(dbURL.Path, "/"), dbURL.RawQuery))
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	config.Net = "tcp"
+	config.Addr = dbURL.Host
+	config.User = dbURL.User.Username()
+	config.Passwd, _ = dbURL.User.Password()
+	return engines.NewMySQLEngine(ctx, config.FormatDSN(), options...)
+}
+
+func openSQLiteURL(ctx context.Context, dbURL *url.URL, options ...types.EngineOptions) (types.Engine, error) {
+	path := dbURL.Opaque
+	if path == "" {
+		path = dbURL.Host + dbURL.Path
+	}
+	conn := "file:" + path
+	if dbURL.RawQuery != "" {
+		conn += "?" + dbURL.RawQuery
+	}
+	return engines.NewSQLiteEngine(ctx, conn, options...)
+}
+
+func openMemoryURL(_ context.Context, _ *url.URL, options ...types.EngineOptions) (types.Engine, error) {
+	return engines.NewMemoryEngine(options...), nil
+}
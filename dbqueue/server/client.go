@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ClientOptions struct {
+	HTTPClient *http.Client
+}
+
+func (o *ClientOptions) Defaults() *ClientOptions {
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	return o
+}
+
+type Client struct {
+	baseURL string
+	options *ClientOptions
+}
+
+func NewClient(baseURL string, options ...ClientOptions) *Client {
+	clientOptions := common.First(options)
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		options: clientOptions.Defaults(),
+	}
+}
+
+func (p *Client) ListQueues(ctx context.Context) ([]string, error) {
+	var response queuesResponse
+	if requestErr := p.call(ctx, http.MethodGet, "/queues", nil, &response); requestErr != nil {
+		return nil, requestErr
+	}
+	return response.Queues, nil
+}
+
+func (p *Client) CreateQueue(ctx context.Context, name string) (types.Queue, error) {
+	if requestErr := p.call(ctx, http.MethodPost, "/queues", createQueueRequest{Name: name},
+		nil); requestErr != nil {
+		return nil, requestErr
+	}
+	return p.OpenQueue(name), nil
+}
+
+func (p *Client) OpenQueue(name string) types.Queue {
+	return &clientQueue{client: p, path: "/queues/" + url.PathEscape(name)}
+}
+
+func (p *Client) DeleteQueue(ctx context.Context, name string) error {
+	return p.call(ctx, http.MethodDelete, "/queues/"+url.PathEscape(name), nil, nil)
+}
+
+func (p *Client) PurgeQueue(ctx context.Context, name string) error {
+	return p.call(ctx, http.MethodPost, "/queues/"+url.PathEscape(name)+"/purge", nil, nil)
+}
+
+func (p *Client) MigrateQueue(ctx context.Context, name string) error {
+	return p.call(ctx, http.MethodPost, "/queues/"+url.PathEscape(name)+"/migrate", nil, nil)
+}
+
+func (p *Client) call(ctx context.Context, method, path string, request, response any) error {
+	var body io.Reader
+	if request != nil {
+		encoded, marshalErr := json.Marshal(request)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	httpResponse, doErr := p.do(ctx, method, path, body, "application/json")
+	if doErr != nil {
+		return doErr
+	}
+	defer httpResponse.Body.Close()
+
+	if response == nil {
+		_, discardErr := io.Copy(io.Discard, httpResponse.Body)
+		return discardErr
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+func (p *Client) do(ctx context.Context, method, path string, body io.Reader,
+	contentType string) (*http.Response, error) {
+	request, requestErr := http.NewRequestWithContext(ctx, method, p.baseURL+path, body)
+	if requestErr != nil {
+		return nil, requestErr
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	response, doErr := p.options.HTTPClient.Do(request)
+	if doErr != nil {
+		return nil, doErr
+	}
+	if response.StatusCode < http.StatusBadRequest {
+		return response, nil
+	}
+	defer response.Body.Close()
+
+	var errResponse errorResponse
+	if decodeErr := json.NewDecoder(response.Body).Decode(&errResponse); decodeErr != nil {
+		errResponse.Message = response.Status
+	}
+	return nil, newError(response.StatusCode, errResponse)
+}
+
+type clientQueue struct {
+	client *Client
+	path   string
+}
+
+func (p *clientQueue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	opts := options.Defaults()
+	for {
+		started := time.Now()
+		messages, receiveErr := p.receive(ctx, opts, *opts.WaitTime)
+		if receiveErr != nil {
+			return receiveErr
+		}
+
+		for _, message := range messages {
+			fun(message)
+		}
+
+		if len(messages) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(opts.PollInterval() - time.Since(started)):
+			}
+		}
+	}
+}
+
+func (p *clientQueue) ClaimMessages(ctx context.Context,
+	options types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	return p.receive(ctx, options.Defaults(), 0)
+}
+
+func (p *clientQueue) receive(ctx context.Context, options *types.ReceiveMessageOptions,
+	waitTime time.Duration) ([]types.ReceivedMessage, error) {
+	granted := *options.MaxNumberOfMessages
+	if options.RateLimiter != nil {
+		acquired, acquireErr := options.RateLimiter.Acquire(ctx, granted)
+		if acquireErr != nil {
+			return nil, acquireErr
+		}
+		granted = acquired
+	}
+
+	var response messagesResponse
+	if requestErr := p.client.call(ctx, http.MethodPost, p.path+"/messages/receive", receiveRequest{
+		MaxNumberOfMessages: granted,
+		VisibilityTimeoutMs: common.Ptr(milliseconds(*options.VisibilityTimeout)),
+		WaitTimeMs:          waitTime.Milliseconds(),
+	}, &response); requestErr != nil {
+		return nil, requestErr
+	}
+
+	messages := make([]types.ReceivedMessage, 0, len(response.Messages))
+	for _, received := range response.Messages {
+		messages = append(messages, received.decode())
+	}
+
+	if options.RateLimiter != nil && granted > len(messages) {
+		if releaseErr := options.RateLimiter.Release(ctx, granted-len(messages)); releaseErr != nil {
+			return nil, releaseErr
+		}
+	}
+	return messages, nil
+}
+
+func (p *clientQueue) SendMessage(ctx context.Context, message *types.Message) error {
+	return p.client.call(ctx, http.MethodPost, p.path+"/messages", encodeMessage(types.ReceivedMessage{
+		Message: *message,
+	}), nil)
+}
+
+func (p *clientQueue) SendMessageBatch(ctx context.Context, messages []*types.Message) error {
+	request := sendBatchRequest{Messages: make([]message, 0, len(messages))}
+	for _, sent := range messages {
+		request.Messages = append(request.Messages, encodeMessage(types.ReceivedMessage{Message: *sent}))
+	}
+	return p.client.call(ctx, http.MethodPost, p.path+"/messages/batch", request, nil)
+}
+
+func (p *clientQueue) DeleteMessage(ctx context.Context, id uint) error {
+	return p.client.call(ctx, http.MethodDelete, fmt.Sprintf("%s/messages/%d", p.path, id), nil, nil)
+}
+
+func (p *clientQueue) DeleteMessageBatch(ctx context.Context, ids []uint) error {
+	return p.client.call(ctx, http.MethodPost, p.path+"/messages/delete", idsRequest{IDs: ids}, nil)
+}
+
+func (p *clientQueue) ChangeMessageVisibility(ctx context.Context, id uint, visibilityTimeout time.Duration) error {
+	return p.client.call(ctx, http.MethodPost, fmt.Sprintf("%s/messages/%d/visibility", p.path, id), idsRequest{
+		VisibilityTimeoutMs: milliseconds(visibilityTimeout),
+	}, nil)
+}
+
+func (p *clientQueue) ChangeMessageVisibilityBatch(ctx context.Context, ids []uint,
+	visibilityTimeout time.Duration) error {
+	return p.client.call(ctx, http.MethodPost, p.path+"/messages/visibility", idsRequest{
+		IDs:                 ids,
+		VisibilityTimeoutMs: milliseconds(visibilityTimeout),
+	}, nil)
+}
+
+func (p *clientQueue) DeleteWhere(ctx context.Context, messageFilter types.MessageFilter) (int, error) {
+	var response countResponse
+	requestErr := p.client.call(ctx, http.MethodPost, p.path+"/messages/delete-where", filterRequest{
+		Filter: encodeFilter(messageFilter),
+	}, &response)
+	return response.Count, requestErr
+}
+
+func (p *clientQueue) RescheduleWhere(ctx context.Context, messageFilter types.MessageFilter,
+	visibilityTimeout time.Duration) (int, error) {
+	var response countResponse
+	requestErr := p.client.call(ctx, http.MethodPost, p.path+"/messages/reschedule-where", filterRequest{
+		Filter:              encodeFilter(messageFilter),
+		VisibilityTimeoutMs: milliseconds(visibilityTimeout),
+	}, &response)
+	return response.Count, requestErr
+}
+
+func (p *clientQueue) Export(ctx context.Context, w io.Writer, options ...types.ExportOptions) (int, error) {
+	exportOptions := common.First(options)
+	exportOptions.Defaults()
+
+	query := url.Values{}
+	query.Set("after_id", strconv.FormatUint(uint64(*exportOptions.AfterID), 10))
+	query.Set("limit", strconv.Itoa(*exportOptions.Limit))
+	response, doErr := p.client.do(ctx, http.MethodGet, p.path+"/export?"+query.Encode(), nil, "")
+	if doErr != nil {
+		return 0, doErr
+	}
+	defer response.Body.Close()
+
+	lines := &lineCounter{writer: w}
+	_, copyErr := io.Copy(lines, response.Body)
+	return lines.count, copyErr
+}
+
+func (p *clientQueue) Import(ctx context.Context, r io.Reader, options ...types.ImportOptions) (int, error) {
+	importOptions := common.First(options)
+	importOptions.Defaults()
+
+	path := p.path + "/import?batch_size=" + strconv.Itoa(*importOptions.BatchSize)
+	response, doErr := p.client.do(ctx, http.MethodPost, path, r, "application/x-ndjson")
+	if doErr != nil {
+		return 0, doErr
+	}
+	defer response.Body.Close()
+
+	var count countResponse
+	if decodeErr := json.NewDecoder(response.Body).Decode(&count); decodeErr != nil {
+		return 0, decodeErr
+	}
+	return count.Count, nil
+}
+
+func (p *clientQueue) ReEncrypt(ctx context.Context) (int, error) {
+	var response countResponse
+	requestErr := p.client.call(ctx, http.MethodPost, p.path+"/reencrypt", nil, &response)
+	return response.Count, requestErr
+}
+
+func (p *clientQueue) Stats(ctx context.Context) (*types.QueueStats, error) {
+	var response statsResponse
+	if requestErr := p.client.call(ctx, http.MethodGet, p.path+"/stats", nil, &response); requestErr != nil {
+		return nil, requestErr
+	}
+	return &types.QueueStats{
+		Depth:     response.Depth,
+		Visible:   response.Visible,
+		OldestAge: time.Duration(response.OldestAgeMs) * time.Millisecond,
+	}, nil
+}
+
+type lineCounter struct {
+	writer io.Writer
+	count  int
+}
+
+func (p *lineCounter) Write(data []byte) (int, error) {
+	written, writeErr := p.writer.Write(data)
+	p.count += bytes.Count(data[:written], []byte{'\n'})
+	return written, writeErr
+}
+
+// milliseconds rounds a visibility timeout up to the milliseconds of the wire format, so it is never shortened.
+func milliseconds(duration time.Duration) int64 {
+	return (duration + time.Millisecond - 1).Milliseconds()
+}
@@ -0,0 +1,469 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Options struct {
+	QueueOptions func(name string) types.QueueOptions
+	MaxWaitTime  *time.Duration
+	PollInterval *time.Duration
+	MaxMessages  *int
+	MaxBodyBytes *int64
+}
+
+func (o *Options) Defaults() *Options {
+	if o.QueueOptions == nil {
+		o.QueueOptions = func(string) types.QueueOptions {
+			return types.QueueOptions{}
+		}
+	}
+	if o.MaxWaitTime == nil {
+		o.MaxWaitTime = common.Ptr(20 * time.Second)
+	}
+	if o.PollInterval == nil {
+		o.PollInterval = common.Ptr(200 * time.Millisecond)
+	}
+	if o.MaxMessages == nil {
+		o.MaxMessages = common.Ptr(100)
+	}
+	if o.MaxBodyBytes == nil {
+		o.MaxBodyBytes = common.Ptr[int64](16 << 20)
+	}
+	return o
+}
+
+type handler struct {
+	engine  types.Engine
+	options *Options
+	mutex   sync.Mutex
+	queues  map[string]types.Queue
+}
+
+func NewHandler(engine types.Engine, options ...Options) http.Handler {
+	handlerOptions := common.First(options)
+	p := &handler{
+		engine:  engine,
+		options: handlerOptions.Defaults(),
+		queues:  map[string]types.Queue{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /queues", p.respond(p.listQueues))
+	mux.HandleFunc("POST /queues", p.respond(p.createQueue))
+	mux.HandleFunc("DELETE /queues/{queue}", p.respond(p.deleteQueue))
+	mux.HandleFunc("POST /queues/{queue}/purge", p.respond(p.purgeQueue))
+	mux.HandleFunc("POST /queues/{queue}/migrate", p.respond(p.migrateQueue))
+	mux.HandleFunc("GET /queues/{queue}/stats", p.respond(p.stats))
+	mux.HandleFunc("POST /queues/{queue}/reencrypt", p.respond(p.reEncrypt))
+	mux.HandleFunc("GET /queues/{queue}/export", p.export)
+	mux.HandleFunc("POST /queues/{queue}/import", p.respond(p.importMessages))
+	mux.HandleFunc("POST /queues/{queue}/messages", p.respond(p.sendMessage))
+	mux.HandleFunc("POST /queues/{queue}/messages/batch", p.respond(p.sendMessageBatch))
+	mux.HandleFunc("POST /queues/{queue}/messages/receive", p.respond(p.receiveMessages))
+	mux.HandleFunc("DELETE /queues/{queue}/messages/{id}", p.respond(p.deleteMessage))
+	mux.HandleFunc("POST /queues/{queue}/messages/delete", p.respond(p.deleteMessageBatch))
+	mux.HandleFunc("POST /queues/{queue}/messages/{id}/visibility", p.respond(p.changeMessageVisibility))
+	mux.HandleFunc("POST /queues/{queue}/messages/visibility", p.respond(p.changeMessageVisibilityBatch))
+	mux.HandleFunc("POST /queues/{queue}/messages/delete-where", p.respond(p.deleteWhere))
+	mux.HandleFunc("POST /queues/{queue}/messages/reschedule-where", p.respond(p.rescheduleWhere))
+	return mux
+}
+
+func (p *handler) respond(fun func(request *http.Request) (int, any, error)) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		status, response, handleErr := fun(request)
+		if handleErr != nil {
+			fail(writer, handleErr)
+			return
+		}
+		write(writer, status, response)
+	}
+}
+
+func fail(writer http.ResponseWriter, err error) {
+	status, response := encodeError(err)
+	write(writer, status, response)
+}
+
+func write(writer http.ResponseWriter, status int, response any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(response)
+}
+
+func (p *handler) decode(request *http.Request, value any) error {
+	body := http.MaxBytesReader(nil, request.Body, *p.options.MaxBodyBytes)
+	if decodeErr := json.NewDecoder(body).Decode(value); decodeErr != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRequest, decodeErr)
+	}
+	return nil
+}
+
+func (p *handler) queue(ctx context.Context, name string) (types.Queue, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if queue, found := p.queues[name]; found {
+		return queue, nil
+	}
+
+	queue, openErr := p.engine.OpenQueue(ctx, name, p.options.QueueOptions(name))
+	if openErr != nil {
+		return nil, openErr
+	}
+	p.queues[name] = queue
+	return queue, nil
+}
+
+func (p *handler) forget(name string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.queues, name)
+}
+
+func (p *handler) listQueues(request *http.Request) (int, any, error) {
+	names, listErr := p.engine.ListQueues(request.Context())
+	if listErr != nil {
+		return 0, nil, listErr
+	}
+	if names == nil {
+		names = []string{}
+	}
+	return http.StatusOK, queuesResponse{Queues: names}, nil
+}
+
+func (p *handler) createQueue(request *http.Request) (int, any, error) {
+	var body createQueueRequest
+	if decodeErr := p.decode(request, &body); decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+
+	queue, createErr := p.engine.CreateQueue(request.Context(), body.Name, p.options.QueueOptions(body.Name))
+	if createErr != nil {
+		return 0, nil, createErr
+	}
+
+	p.mutex.Lock()
+	p.queues[body.Name] = queue
+	p.mutex.Unlock()
+	return http.StatusCreated, body, nil
+}
+
+func (p *handler) deleteQueue(request *http.Request) (int, any, error) {
+	name := request.PathValue("queue")
+	p.forget(name)
+	if deleteErr := p.engine.DeleteQueue(request.Context(), name, p.options.QueueOptions(name)); deleteErr != nil {
+		return 0, nil, deleteErr
+	}
+	return http.StatusOK, struct{}{}, nil
+}
+
+func (p *handler) purgeQueue(request *http.Request) (int, any, error) {
+	name := request.PathValue("queue")
+	if purgeErr := p.engine.PurgeQueue(request.Context(), name, p.options.QueueOptions(name)); purgeErr != nil {
+		return 0, nil, purgeErr
+	}
+	return http.StatusOK, struct{}{}, nil
+}
+
+func (p *handler) migrateQueue(request *http.Request) (int, any, error) {
+	name := request.PathValue("queue")
+	p.forget(name)
+	if migrateErr := p.engine.MigrateQueue(request.Context(), name); migrateErr != nil {
+		return 0, nil, migrateErr
+	}
+	return http.StatusOK, struct{}{}, nil
+}
+
+func (p *handler) stats(request *http.Request) (int, any, error) {
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		return 0, nil, queueErr
+	}
+
+	stats, statsErr := queue.Stats(request.Context())
+	if statsErr != nil {
+		return 0, nil, statsErr
+	}
+	return http.StatusOK, statsResponse{
+		Depth:       stats.Depth,
+		Visible:     stats.Visible,
+		OldestAgeMs: stats.OldestAge.Milliseconds(),
+	}, nil
+}
+
+func (p *handler) reEncrypt(request *http.Request) (int, any, error) {
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		return 0, nil, queueErr
+	}
+
+	count, reencryptErr := queue.ReEncrypt(request.Context())
+	if reencryptErr != nil {
+		return 0, nil, reencryptErr
+	}
+	return http.StatusOK, countResponse{Count: count}, nil
+}
+
+func (p *handler) export(writer http.ResponseWriter, request *http.Request) {
+	exportOptions, parseErr := exportOptions(request)
+	if parseErr != nil {
+		fail(writer, parseErr)
+		return
+	}
+
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		fail(writer, queueErr)
+		return
+	}
+
+	stream := &streamWriter{writer: writer}
+	writer.Header().Set("Content-Type", "application/x-ndjson")
+	if _, exportErr := queue.Export(request.Context(), stream, exportOptions); exportErr != nil {
+		if !stream.started {
+			fail(writer, exportErr)
+			return
+		}
+		// The status line is already sent, so the only way left to signal the failure is to cut the stream short.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func exportOptions(request *http.Request) (types.ExportOptions, error) {
+	var exportOptions types.ExportOptions
+	if raw := request.URL.Query().Get("after_id"); raw != "" {
+		afterID, parseErr := strconv.ParseUint(raw, 10, 64)
+		if parseErr != nil {
+			return exportOptions, fmt.Errorf("%w: after_id: %s", ErrInvalidRequest, parseErr)
+		}
+		exportOptions.AfterID = common.Ptr(uint(afterID))
+	}
+	if raw := request.URL.Query().Get("limit"); raw != "" {
+		limit, parseErr := strconv.Atoi(raw)
+		if parseErr != nil {
+			return exportOptions, fmt.Errorf("%w: limit: %s", ErrInvalidRequest, parseErr)
+		}
+		exportOptions.Limit = &limit
+	}
+	return exportOptions, nil
+}
+
+type streamWriter struct {
+	writer  io.Writer
+	started bool
+}
+
+func (p *streamWriter) Write(data []byte) (int, error) {
+	p.started = true
+	return p.writer.Write(data)
+}
+
+func (p *handler) importMessages(request *http.Request) (int, any, error) {
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		return 0, nil, queueErr
+	}
+
+	var importOptions types.ImportOptions
+	if raw := request.URL.Query().Get("batch_size"); raw != "" {
+		batchSize, parseErr := strconv.Atoi(raw)
+		if parseErr != nil {
+			return 0, nil, fmt.Errorf("%w: batch_size: %s", ErrInvalidRequest, parseErr)
+		}
+		importOptions.BatchSize = &batchSize
+	}
+
+	count, importErr := queue.Import(request.Context(), request.Body, importOptions)
+	if importErr != nil {
+		return 0, nil, importErr
+	}
+	return http.StatusOK, countResponse{Count: count}, nil
+}
+
+func (p *handler) sendMessage(request *http.Request) (int, any, error) {
+	var body message
+	if decodeErr := p.decode(request, &body); decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+	return p.send(request, []message{body})
+}
+
+func (p *handler) sendMessageBatch(request *http.Request) (int, any, error) {
+	var body sendBatchRequest
+	if decodeErr := p.decode(request, &body); decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+	return p.send(request, body.Messages)
+}
+
+func (p *handler) send(request *http.Request, messages []message) (int, any, error) {
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		return 0, nil, queueErr
+	}
+
+	batch := make([]*types.Message, 0, len(messages))
+	for _, sent := range messages {
+		batch = append(batch, common.Ptr(sent.decode().Message))
+	}
+	if sendErr := queue.SendMessageBatch(request.Context(), batch); sendErr != nil {
+		return 0, nil, sendErr
+	}
+	return http.StatusOK, countResponse{Count: len(batch)}, nil
+}
+
+func (p *handler) receiveMessages(request *http.Request) (int, any, error) {
+	var body receiveRequest
+	if decodeErr := p.decode(request, &body); decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		return 0, nil, queueErr
+	}
+
+	maxMessages := body.MaxNumberOfMessages
+	if maxMessages <= 0 || maxMessages > *p.options.MaxMessages {
+		maxMessages = *p.options.MaxMessages
+	}
+	receiveOptions := types.ReceiveMessageOptions{MaxNumberOfMessages: &maxMessages}
+	if body.VisibilityTimeoutMs != nil {
+		receiveOptions.VisibilityTimeout = common.Ptr(time.Duration(*body.VisibilityTimeoutMs) * time.Millisecond)
+	}
+	deadline := time.Now().Add(min(time.Duration(body.WaitTimeMs)*time.Millisecond, *p.options.MaxWaitTime))
+
+	for {
+		messages, claimErr := queue.ClaimMessages(request.Context(), receiveOptions)
+		if claimErr != nil {
+			return 0, nil, claimErr
+		}
+
+		remaining := time.Until(deadline)
+		if len(messages) != 0 || remaining <= 0 {
+			response := messagesResponse{Messages: make([]message, 0, len(messages))}
+			for _, received := range messages {
+				response.Messages = append(response.Messages, encodeMessage(received))
+			}
+			return http.StatusOK, response, nil
+		}
+
+		select {
+		case <-request.Context().Done():
+			return 0, nil, request.Context().Err()
+		case <-time.After(min(remaining, *p.options.PollInterval)):
+		}
+	}
+}
+
+func (p *handler) deleteMessage(request *http.Request) (int, any, error) {
+	id, parseErr := strconv.ParseUint(request.PathValue("id"), 10, 64)
+	if parseErr != nil {
+		return 0, nil, fmt.Errorf("%w: id: %s", ErrInvalidRequest, parseErr)
+	}
+	return p.deleteIDs(request, []uint{uint(id)})
+}
+
+func (p *handler) deleteMessageBatch(request *http.Request) (int, any, error) {
+	var body idsRequest
+	if decodeErr := p.decode(request, &body); decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+	return p.deleteIDs(request, body.IDs)
+}
+
+func (p *handler) deleteIDs(request *http.Request, ids []uint) (int, any, error) {
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		return 0, nil, queueErr
+	}
+
+	if deleteErr := queue.DeleteMessageBatch(request.Context(), ids); deleteErr != nil {
+		return 0, nil, deleteErr
+	}
+	return http.StatusOK, struct{}{}, nil
+}
+
+func (p *handler) changeMessageVisibility(request *http.Request) (int, any, error) {
+	id, parseErr := strconv.ParseUint(request.PathValue("id"), 10, 64)
+	if parseErr != nil {
+		return 0, nil, fmt.Errorf("%w: id: %s", ErrInvalidRequest, parseErr)
+	}
+
+	var body idsRequest
+	if decodeErr := p.decode(request, &body); decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+	body.IDs = []uint{uint(id)}
+	return p.changeVisibility(request, body)
+}
+
+func (p *handler) changeMessageVisibilityBatch(request *http.Request) (int, any, error) {
+	var body idsRequest
+	if decodeErr := p.decode(request, &body); decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+	return p.changeVisibility(request, body)
+}
+
+func (p *handler) changeVisibility(request *http.Request, body idsRequest) (int, any, error) {
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		return 0, nil, queueErr
+	}
+
+	visibilityTimeout := time.Duration(body.VisibilityTimeoutMs) * time.Millisecond
+	if changeErr := queue.ChangeMessageVisibilityBatch(request.Context(), body.IDs,
+		visibilityTimeout); changeErr != nil {
+		return 0, nil, changeErr
+	}
+	return http.StatusOK, struct{}{}, nil
+}
+
+func (p *handler) deleteWhere(request *http.Request) (int, any, error) {
+	var body filterRequest
+	if decodeErr := p.decode(request, &body); decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		return 0, nil, queueErr
+	}
+
+	count, deleteErr := queue.DeleteWhere(request.Context(), body.Filter.decode())
+	if deleteErr != nil {
+		return 0, nil, deleteErr
+	}
+	return http.StatusOK, countResponse{Count: count}, nil
+}
+
+func (p *handler) rescheduleWhere(request *http.Request) (int, any, error) {
+	var body filterRequest
+	if decodeErr := p.decode(request, &body); decodeErr != nil {
+		return 0, nil, decodeErr
+	}
+
+	queue, queueErr := p.queue(request.Context(), request.PathValue("queue"))
+	if queueErr != nil {
+		return 0, nil, queueErr
+	}
+
+	count, rescheduleErr := queue.RescheduleWhere(request.Context(), body.Filter.decode(),
+		time.Duration(body.VisibilityTimeoutMs)*time.Millisecond)
+	if rescheduleErr != nil {
+		return 0, nil, rescheduleErr
+	}
+	return http.StatusOK, countResponse{Count: count}, nil
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"errors"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"net/http"
+)
+
+var ErrInvalidRequest = errors.New("invalid request")
+
+var errorCodes = []struct {
+	code   string
+	status int
+	err    error
+}{
+	{code: "invalid_request", status: http.StatusBadRequest, err: ErrInvalidRequest},
+	{code: "queue_not_found", status: http.StatusNotFound, err: types.ErrQueueNotFound},
+	{code: "invalid_queue_name", status: http.StatusBadRequest, err: types.ErrInvalidQueueName},
+	{code: "invalid_message_filter", status: http.StatusBadRequest, err: types.ErrInvalidMessageFilter},
+	{code: "invalid_priority_aging", status: http.StatusBadRequest, err: types.ErrInvalidPriorityAging},
+	{code: "export_version_not_supported", status: http.StatusBadRequest, err: types.ErrExportVersionNotSupported},
+	{code: "invalid_queue_schema", status: http.StatusConflict, err: types.ErrInvalidQueueSchema},
+	{code: "queue_schema_outdated", status: http.StatusConflict, err: types.ErrQueueSchemaOutdated},
+	{code: "queue_schema_version_not_supported", status: http.StatusConflict,
+		err: types.ErrQueueSchemaVersionNotSupported},
+	{code: "key_provider_not_configured", status: http.StatusConflict, err: types.ErrKeyProviderNotConfigured},
+}
+
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+func newError(status int, response errorResponse) *Error {
+	remoteErr := &Error{Status: status, Code: response.Code, Message: response.Message}
+	for _, errorCode := range errorCodes {
+		if errorCode.code == response.Code {
+			remoteErr.err = errorCode.err
+		}
+	}
+	return remoteErr
+}
+
+func encodeError(err error) (int, errorResponse) {
+	for _, errorCode := range errorCodes {
+		if errors.Is(err, errorCode.err) {
+			return errorCode.status, errorResponse{Code: errorCode.code, Message: err.Error()}
+		}
+	}
+	return http.StatusInternalServerError, errorResponse{Code: "internal_error", Message: err.Error()}
+}
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type message struct {
+	ID              uint              `json:"id,omitempty"`
+	Payload         []byte            `json:"payload"`
+	Priority        uint32            `json:"priority,omitempty"`
+	DeduplicationID *string           `json:"deduplication_id,omitempty"`
+	VisibleAfter    *int64            `json:"visible_after,omitempty"`
+	Retrieval       uint32            `json:"retrieval,omitempty"`
+	CreatedAt       int64             `json:"created_at,omitempty"`
+	Attributes      map[string]string `json:"attributes,omitempty"`
+}
+
+func encodeMessage(received types.ReceivedMessage) message {
+	return message{
+		ID:              received.ID,
+		Payload:         received.Payload,
+		Priority:        received.Priority,
+		DeduplicationID: received.DeduplicationID,
+		VisibleAfter:    received.VisibleAfter,
+		Retrieval:       received.Retrieval,
+		CreatedAt:       received.CreatedAt,
+		Attributes:      received.Attributes,
+	}
+}
+
+func (m message) decode() types.ReceivedMessage {
+	return types.ReceivedMessage{
+		Message: types.Message{
+			Payload:         m.Payload,
+			Priority:        m.Priority,
+			DeduplicationID: m.DeduplicationID,
+			VisibleAfter:    m.VisibleAfter,
+			Attributes:      m.Attributes,
+		},
+		ID:        m.ID,
+		Retrieval: m.Retrieval,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+type filter struct {
+	ID                    *uint             `json:"id,omitempty"`
+	MinPriority           *uint32           `json:"min_priority,omitempty"`
+	MaxPriority           *uint32           `json:"max_priority,omitempty"`
+	CreatedAfter          *int64            `json:"created_after,omitempty"`
+	CreatedBefore         *int64            `json:"created_before,omitempty"`
+	RetrievalAbove        *uint32           `json:"retrieval_above,omitempty"`
+	Retrieval             *uint32           `json:"retrieval,omitempty"`
+	Attributes            map[string]string `json:"attributes,omitempty"`
+	DeduplicationIDPrefix *string           `json:"deduplication_id_prefix,omitempty"`
+	ChunkSize             *int              `json:"chunk_size,omitempty"`
+}
+
+func encodeFilter(messageFilter types.MessageFilter) filter {
+	return filter(messageFilter)
+}
+
+func (f filter) decode() types.MessageFilter {
+	return types.MessageFilter(f)
+}
+
+type createQueueRequest struct {
+	Name string `json:"name"`
+}
+
+type queuesResponse struct {
+	Queues []string `json:"queues"`
+}
+
+type sendBatchRequest struct {
+	Messages []message `json:"messages"`
+}
+
+type receiveRequest struct {
+	MaxNumberOfMessages int    `json:"max_number_of_messages,omitempty"`
+	VisibilityTimeoutMs *int64 `json:"visibility_timeout_ms,omitempty"`
+	WaitTimeMs          int64  `json:"wait_time_ms,omitempty"`
+}
+
+type messagesResponse struct {
+	Messages []message `json:"messages"`
+}
+
+type idsRequest struct {
+	IDs                 []uint `json:"ids"`
+	VisibilityTimeoutMs int64  `json:"visibility_timeout_ms,omitempty"`
+}
+
+type filterRequest struct {
+	Filter              filter `json:"filter"`
+	VisibilityTimeoutMs int64  `json:"visibility_timeout_ms,omitempty"`
+}
+
+type countResponse struct {
+	Count int `json:"count"`
+}
+
+type statsResponse struct {
+	Depth       int   `json:"depth"`
+	Visible     int   `json:"visible"`
+	OldestAgeMs int64 `json:"oldest_age_ms"`
+}
@@ -0,0 +1,639 @@
+package sqs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxBatchEntries = 10
+
+// dataTypePrefix names the attribute that keeps the SQS data type of a message attribute when it is not String.
+const dataTypePrefix = "sqs.DataType."
+
+type messageAttributeValue struct {
+	DataType    string  `json:"DataType"`
+	StringValue *string `json:"StringValue,omitempty"`
+	BinaryValue []byte  `json:"BinaryValue,omitempty"`
+}
+
+type queueRequest struct {
+	QueueURL string `json:"QueueUrl"`
+}
+
+type queueURLResponse struct {
+	QueueURL string `json:"QueueUrl"`
+}
+
+type sendEntry struct {
+	ID                     string                           `json:"Id"`
+	MessageBody            string                           `json:"MessageBody"`
+	DelaySeconds           *int64                           `json:"DelaySeconds"`
+	MessageAttributes      map[string]messageAttributeValue `json:"MessageAttributes"`
+	MessageDeduplicationID *string                          `json:"MessageDeduplicationId"`
+}
+
+type sendResult struct {
+	ID                     string `json:"Id,omitempty"`
+	MessageID              string `json:"MessageId"`
+	MD5OfMessageBody       string `json:"MD5OfMessageBody"`
+	MD5OfMessageAttributes string `json:"MD5OfMessageAttributes,omitempty"`
+}
+
+type receiptEntry struct {
+	ID                string `json:"Id"`
+	ReceiptHandle     string `json:"ReceiptHandle"`
+	VisibilityTimeout int64  `json:"VisibilityTimeout"`
+}
+
+// receipt identifies one delivery of a message. The retrieval count makes a handle stale once the message is received
+// again, so a consumer that lost its message cannot delete or extend the new delivery, as on SQS.
+type receipt struct {
+	id        uint
+	retrieval uint32
+}
+
+func (r receipt) filter() types.MessageFilter {
+	return types.MessageFilter{ID: common.Ptr(r.id), Retrieval: common.Ptr(r.retrieval)}
+}
+
+func (r receipt) String() string {
+	return strconv.FormatUint(uint64(r.id), 10) + "." + strconv.FormatUint(uint64(r.retrieval), 10)
+}
+
+type batchFailure struct {
+	ID          string `json:"Id"`
+	SenderFault bool   `json:"SenderFault"`
+	Code        string `json:"Code"`
+	Message     string `json:"Message"`
+}
+
+type batchSuccess struct {
+	ID string `json:"Id"`
+}
+
+type receivedMessage struct {
+	MessageID              string                           `json:"MessageId"`
+	ReceiptHandle          string                           `json:"ReceiptHandle"`
+	MD5OfBody              string                           `json:"MD5OfBody"`
+	Body                   string                           `json:"Body"`
+	Attributes             map[string]string                `json:"Attributes,omitempty"`
+	MessageAttributes      map[string]messageAttributeValue `json:"MessageAttributes,omitempty"`
+	MD5OfMessageAttributes string                           `json:"MD5OfMessageAttributes,omitempty"`
+}
+
+func (p *handler) createQueue(request *http.Request, body []byte) (any, error) {
+	var input struct {
+		QueueName string `json:"QueueName"`
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	queue, createErr := p.engine.CreateQueue(request.Context(), input.QueueName,
+		p.options.QueueOptions(input.QueueName))
+	if createErr != nil {
+		return nil, createErr
+	}
+
+	p.mutex.Lock()
+	p.queues[input.QueueName] = queue
+	p.mutex.Unlock()
+	return queueURLResponse{QueueURL: p.queueURL(request, input.QueueName)}, nil
+}
+
+func (p *handler) getQueueURL(request *http.Request, body []byte) (any, error) {
+	var input struct {
+		QueueName string `json:"QueueName"`
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	queueURL := p.queueURL(request, input.QueueName)
+	if _, queueErr := p.queue(request.Context(), queueURL); queueErr != nil {
+		return nil, queueErr
+	}
+	return queueURLResponse{QueueURL: queueURL}, nil
+}
+
+func (p *handler) listQueues(request *http.Request, body []byte) (any, error) {
+	var input struct {
+		QueueNamePrefix string `json:"QueueNamePrefix"`
+		MaxResults      int    `json:"MaxResults"`
+		NextToken       string `json:"NextToken"`
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	names, listErr := p.engine.ListQueues(request.Context())
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	var output struct {
+		QueueUrls []string `json:"QueueUrls"`
+		NextToken string   `json:"NextToken,omitempty"`
+	}
+	output.QueueUrls = []string{}
+	for i, name := range names {
+		if !strings.HasPrefix(name, input.QueueNamePrefix) || name <= input.NextToken {
+			continue
+		}
+		if input.MaxResults > 0 && len(output.QueueUrls) == input.MaxResults {
+			output.NextToken = names[i-1]
+			break
+		}
+		output.QueueUrls = append(output.QueueUrls, p.queueURL(request, name))
+	}
+	return output, nil
+}
+
+func (p *handler) deleteQueue(request *http.Request, body []byte) (any, error) {
+	var input queueRequest
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	name, nameErr := p.queueName(input.QueueURL)
+	if nameErr != nil {
+		return nil, nameErr
+	}
+	p.forget(name)
+	return struct{}{}, p.engine.DeleteQueue(request.Context(), name, p.options.QueueOptions(name))
+}
+
+func (p *handler) purgeQueue(request *http.Request, body []byte) (any, error) {
+	var input queueRequest
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	if _, queueErr := p.queue(request.Context(), input.QueueURL); queueErr != nil {
+		return nil, queueErr
+	}
+	name, _ := p.queueName(input.QueueURL)
+	return struct{}{}, p.engine.PurgeQueue(request.Context(), name, p.options.QueueOptions(name))
+}
+
+func (p *handler) getQueueAttributes(request *http.Request, body []byte) (any, error) {
+	var input struct {
+		QueueURL       string   `json:"QueueUrl"`
+		AttributeNames []string `json:"AttributeNames"`
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	queue, queueErr := p.queue(request.Context(), input.QueueURL)
+	if queueErr != nil {
+		return nil, queueErr
+	}
+	stats, statsErr := queue.Stats(request.Context())
+	if statsErr != nil {
+		return nil, statsErr
+	}
+
+	name, _ := p.queueName(input.QueueURL)
+	receiveDefaults := (&types.ReceiveMessageOptions{}).Defaults()
+	attributes := map[string]string{
+		"ApproximateNumberOfMessages":           strconv.Itoa(stats.Visible),
+		"ApproximateNumberOfMessagesNotVisible": strconv.Itoa(stats.Depth - stats.Visible),
+		"QueueArn":                              "arn:aws:sqs:" + *p.options.Region + ":" + *p.options.AccountID + ":" + name,
+		"VisibilityTimeout":                     strconv.FormatInt(int64(*receiveDefaults.VisibilityTimeout/time.Second), 10),
+	}
+
+	output := struct {
+		Attributes map[string]string `json:"Attributes"`
+	}{Attributes: map[string]string{}}
+	for key, value := range attributes {
+		if requested(input.AttributeNames, key) {
+			output.Attributes[key] = value
+		}
+	}
+	return output, nil
+}
+
+func (p *handler) sendMessage(request *http.Request, body []byte) (any, error) {
+	var input struct {
+		queueRequest
+		sendEntry
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	queue, queueErr := p.queue(request.Context(), input.QueueURL)
+	if queueErr != nil {
+		return nil, queueErr
+	}
+
+	message, result, encodeErr := encodeEntry(input.sendEntry)
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+	if sendErr := queue.SendMessage(request.Context(), message); sendErr != nil {
+		return nil, sendErr
+	}
+	return result, nil
+}
+
+func (p *handler) sendMessageBatch(request *http.Request, body []byte) (any, error) {
+	var input struct {
+		queueRequest
+		Entries []sendEntry `json:"Entries"`
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	ids := make([]string, 0, len(input.Entries))
+	for _, entry := range input.Entries {
+		ids = append(ids, entry.ID)
+	}
+	if batchErr := validateBatch(ids); batchErr != nil {
+		return nil, batchErr
+	}
+
+	queue, queueErr := p.queue(request.Context(), input.QueueURL)
+	if queueErr != nil {
+		return nil, queueErr
+	}
+
+	messages := make([]*types.Message, 0, len(input.Entries))
+	output := struct {
+		Successful []sendResult   `json:"Successful"`
+		Failed     []batchFailure `json:"Failed"`
+	}{Successful: []sendResult{}, Failed: []batchFailure{}}
+	for _, entry := range input.Entries {
+		message, result, encodeErr := encodeEntry(entry)
+		if encodeErr != nil {
+			output.Failed = append(output.Failed, batchFailure{ID: entry.ID, SenderFault: true,
+				Code: "InvalidParameterValue", Message: encodeErr.Error()})
+			continue
+		}
+		result.ID = entry.ID
+		messages = append(messages, message)
+		output.Successful = append(output.Successful, result)
+	}
+
+	if sendErr := queue.SendMessageBatch(request.Context(), messages); sendErr != nil {
+		return nil, sendErr
+	}
+	return output, nil
+}
+
+func (p *handler) receiveMessage(request *http.Request, body []byte) (any, error) {
+	var input struct {
+		QueueURL                    string   `json:"QueueUrl"`
+		MaxNumberOfMessages         *int     `json:"MaxNumberOfMessages"`
+		VisibilityTimeout           *int64   `json:"VisibilityTimeout"`
+		WaitTimeSeconds             int64    `json:"WaitTimeSeconds"`
+		AttributeNames              []string `json:"AttributeNames"`
+		MessageSystemAttributeNames []string `json:"MessageSystemAttributeNames"`
+		MessageAttributeNames       []string `json:"MessageAttributeNames"`
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	maxMessages := 1
+	if input.MaxNumberOfMessages != nil {
+		maxMessages = *input.MaxNumberOfMessages
+	}
+	if maxMessages < 1 || maxMessages > maxBatchEntries {
+		return nil, invalidParameter("MaxNumberOfMessages must be between 1 and %d", maxBatchEntries)
+	}
+	if input.WaitTimeSeconds < 0 || input.WaitTimeSeconds > 20 {
+		return nil, invalidParameter("WaitTimeSeconds must be between 0 and 20")
+	}
+
+	queue, queueErr := p.queue(request.Context(), input.QueueURL)
+	if queueErr != nil {
+		return nil, queueErr
+	}
+
+	receiveOptions := types.ReceiveMessageOptions{MaxNumberOfMessages: &maxMessages}
+	if input.VisibilityTimeout != nil {
+		receiveOptions.VisibilityTimeout = common.Ptr(time.Duration(*input.VisibilityTimeout) * time.Second)
+	}
+	deadline := time.Now().Add(time.Duration(input.WaitTimeSeconds) * time.Second)
+
+	var messages []types.ReceivedMessage
+	for {
+		claimed, claimErr := queue.ClaimMessages(request.Context(), receiveOptions)
+		if claimErr != nil {
+			return nil, claimErr
+		}
+
+		remaining := time.Until(deadline)
+		if messages = claimed; len(messages) != 0 || remaining <= 0 {
+			break
+		}
+
+		select {
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		case <-time.After(min(remaining, *p.options.PollInterval)):
+		}
+	}
+
+	systemAttributes := append(input.AttributeNames, input.MessageSystemAttributeNames...)
+	output := struct {
+		Messages []receivedMessage `json:"Messages"`
+	}{Messages: make([]receivedMessage, 0, len(messages))}
+	for _, message := range messages {
+		received := receivedMessage{
+			ReceiptHandle: receipt{id: message.ID, retrieval: message.Retrieval}.String(),
+			MD5OfBody:     checksum(message.Payload),
+			Body:          string(message.Payload),
+			Attributes:    map[string]string{},
+		}
+		if message.DeduplicationID != nil {
+			received.MessageID = *message.DeduplicationID
+		}
+
+		for key, value := range map[string]string{
+			"ApproximateReceiveCount": strconv.FormatUint(uint64(message.Retrieval), 10),
+			"SentTimestamp":           strconv.FormatInt(message.CreatedAt*1000, 10),
+		} {
+			if requested(systemAttributes, key) {
+				received.Attributes[key] = value
+			}
+		}
+
+		for key, value := range message.Attributes {
+			if strings.HasPrefix(key, dataTypePrefix) || !requested(input.MessageAttributeNames, key) {
+				continue
+			}
+			dataType, typed := message.Attributes[dataTypePrefix+key]
+			if !typed {
+				dataType = "String"
+			}
+			if received.MessageAttributes == nil {
+				received.MessageAttributes = map[string]messageAttributeValue{}
+			}
+			received.MessageAttributes[key] = messageAttributeValue{DataType: dataType, StringValue: common.Ptr(value)}
+		}
+		received.MD5OfMessageAttributes = attributesChecksum(received.MessageAttributes)
+		output.Messages = append(output.Messages, received)
+	}
+	return output, nil
+}
+
+func (p *handler) deleteMessage(request *http.Request, body []byte) (any, error) {
+	var input struct {
+		queueRequest
+		receiptEntry
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	handle, handleErr := receiptHandle(input.ReceiptHandle)
+	if handleErr != nil {
+		return nil, handleErr
+	}
+
+	queue, queueErr := p.queue(request.Context(), input.QueueURL)
+	if queueErr != nil {
+		return nil, queueErr
+	}
+	deleted, deleteErr := queue.DeleteWhere(request.Context(), handle.filter())
+	if deleteErr != nil {
+		return nil, deleteErr
+	}
+	if deleted == 0 {
+		return nil, expiredReceipt(input.ReceiptHandle)
+	}
+	return struct{}{}, nil
+}
+
+func (p *handler) deleteMessageBatch(request *http.Request, body []byte) (any, error) {
+	return p.receiptBatch(request, body, func(queue types.Queue, handle receipt, _ receiptEntry) (int, error) {
+		return queue.DeleteWhere(request.Context(), handle.filter())
+	})
+}
+
+func (p *handler) changeMessageVisibility(request *http.Request, body []byte) (any, error) {
+	var input struct {
+		queueRequest
+		receiptEntry
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	handle, handleErr := receiptHandle(input.ReceiptHandle)
+	if handleErr != nil {
+		return nil, handleErr
+	}
+
+	queue, queueErr := p.queue(request.Context(), input.QueueURL)
+	if queueErr != nil {
+		return nil, queueErr
+	}
+	changed, changeErr := queue.RescheduleWhere(request.Context(), handle.filter(),
+		time.Duration(input.VisibilityTimeout)*time.Second)
+	if changeErr != nil {
+		return nil, changeErr
+	}
+	if changed == 0 {
+		return nil, expiredReceipt(input.ReceiptHandle)
+	}
+	return struct{}{}, nil
+}
+
+func (p *handler) changeMessageVisibilityBatch(request *http.Request, body []byte) (any, error) {
+	return p.receiptBatch(request, body, func(queue types.Queue, handle receipt, entry receiptEntry) (int, error) {
+		return queue.RescheduleWhere(request.Context(), handle.filter(),
+			time.Duration(entry.VisibilityTimeout)*time.Second)
+	})
+}
+
+// receiptBatch applies a receipt handle action entry by entry. An entry whose action matched no message failed,
+// since its handle is stale or its message is gone.
+func (p *handler) receiptBatch(request *http.Request, body []byte,
+	apply func(queue types.Queue, handle receipt, entry receiptEntry) (int, error)) (any, error) {
+	var input struct {
+		queueRequest
+		Entries []receiptEntry `json:"Entries"`
+	}
+	if unmarshalErr := json.Unmarshal(body, &input); unmarshalErr != nil {
+		return nil, invalidParameter("%s", unmarshalErr)
+	}
+
+	entryIDs := make([]string, 0, len(input.Entries))
+	for _, entry := range input.Entries {
+		entryIDs = append(entryIDs, entry.ID)
+	}
+	if batchErr := validateBatch(entryIDs); batchErr != nil {
+		return nil, batchErr
+	}
+
+	queue, queueErr := p.queue(request.Context(), input.QueueURL)
+	if queueErr != nil {
+		return nil, queueErr
+	}
+
+	output := struct {
+		Successful []batchSuccess `json:"Successful"`
+		Failed     []batchFailure `json:"Failed"`
+	}{Successful: []batchSuccess{}, Failed: []batchFailure{}}
+	for _, entry := range input.Entries {
+		handle, handleErr := receiptHandle(entry.ReceiptHandle)
+		if handleErr == nil {
+			applied, applyErr := apply(queue, handle, entry)
+			if applyErr != nil {
+				return nil, applyErr
+			}
+			if applied == 0 {
+				handleErr = expiredReceipt(entry.ReceiptHandle)
+			}
+		}
+		if handleErr != nil {
+			output.Failed = append(output.Failed, batchFailure{ID: entry.ID, SenderFault: true,
+				Code: "ReceiptHandleIsInvalid", Message: handleErr.Error()})
+			continue
+		}
+		output.Successful = append(output.Successful, batchSuccess{ID: entry.ID})
+	}
+	return output, nil
+}
+
+func encodeEntry(entry sendEntry) (*types.Message, sendResult, error) {
+	message := &types.Message{
+		Payload:         []byte(entry.MessageBody),
+		DeduplicationID: entry.MessageDeduplicationID,
+	}
+	if message.DeduplicationID == nil {
+		message.DeduplicationID = common.Ptr(uuid.NewString())
+	}
+
+	var delay time.Duration
+	if entry.DelaySeconds != nil {
+		delay = time.Duration(*entry.DelaySeconds) * time.Second
+	}
+	message.VisibleAfter = common.Ptr(common.VisibleAfter(time.Now(), delay))
+
+	for key, value := range entry.MessageAttributes {
+		if value.StringValue == nil {
+			return nil, sendResult{}, invalidParameter("message attribute %q must have a string value", key)
+		}
+		if strings.HasPrefix(key, dataTypePrefix) {
+			return nil, sendResult{}, invalidParameter("message attribute %q uses the reserved prefix %q", key,
+				dataTypePrefix)
+		}
+		if message.Attributes == nil {
+			message.Attributes = map[string]string{}
+		}
+		message.Attributes[key] = *value.StringValue
+		if value.DataType != "String" {
+			message.Attributes[dataTypePrefix+key] = value.DataType
+		}
+	}
+
+	return message, sendResult{
+		MessageID:              *message.DeduplicationID,
+		MD5OfMessageBody:       checksum(message.Payload),
+		MD5OfMessageAttributes: attributesChecksum(entry.MessageAttributes),
+	}, nil
+}
+
+func validateBatch(ids []string) error {
+	if len(ids) == 0 {
+		return &apiError{status: http.StatusBadRequest, code: "EmptyBatchRequest",
+			queryCode: "AWS.SimpleQueueService.EmptyBatchRequest", message: "the batch request has no entries"}
+	}
+	if len(ids) > maxBatchEntries {
+		return &apiError{status: http.StatusBadRequest, code: "TooManyEntriesInBatchRequest",
+			queryCode: "AWS.SimpleQueueService.TooManyEntriesInBatchRequest",
+			message:   "a batch request can have at most 10 entries"}
+	}
+
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			return &apiError{status: http.StatusBadRequest, code: "BatchEntryIdsNotDistinct",
+				queryCode: "AWS.SimpleQueueService.BatchEntryIdsNotDistinct",
+				message:   "batch entry " + id + " is repeated"}
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+func receiptHandle(handle string) (receipt, error) {
+	rawID, rawRetrieval, found := strings.Cut(handle, ".")
+	id, idErr := strconv.ParseUint(rawID, 10, 64)
+	retrieval, retrievalErr := strconv.ParseUint(rawRetrieval, 10, 32)
+	if !found || idErr != nil || retrievalErr != nil {
+		return receipt{}, &apiError{status: http.StatusBadRequest, code: "ReceiptHandleIsInvalid",
+			queryCode: "ReceiptHandleIsInvalid", message: "receipt handle " + strconv.Quote(handle) + " is invalid"}
+	}
+	return receipt{id: uint(id), retrieval: uint32(retrieval)}, nil
+}
+
+func expiredReceipt(handle string) error {
+	return &apiError{status: http.StatusBadRequest, code: "ReceiptHandleIsInvalid",
+		queryCode: "ReceiptHandleIsInvalid", message: "receipt handle " + strconv.Quote(handle) + " has expired"}
+}
+
+func requested(names []string, name string) bool {
+	for _, pattern := range names {
+		if pattern == "All" || pattern == ".*" || pattern == name ||
+			strings.HasSuffix(pattern, ".*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func checksum(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// attributesChecksum follows the SQS message attribute digest: every attribute, sorted by name,
+// contributes its length-prefixed name, data type and value, with a transport marker before the value.
+func attributesChecksum(attributes map[string]messageAttributeValue) string {
+	if len(attributes) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buffer bytes.Buffer
+	prefixed := func(value []byte) {
+		_ = binary.Write(&buffer, binary.BigEndian, uint32(len(value)))
+		buffer.Write(value)
+	}
+	for _, name := range names {
+		attribute := attributes[name]
+		prefixed([]byte(name))
+		prefixed([]byte(attribute.DataType))
+		if attribute.StringValue != nil {
+			buffer.WriteByte(1)
+			prefixed([]byte(*attribute.StringValue))
+		} else {
+			buffer.WriteByte(2)
+			prefixed(attribute.BinaryValue)
+		}
+	}
+	return checksum(buffer.Bytes())
+}
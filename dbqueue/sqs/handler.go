@@ -0,0 +1,203 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const targetPrefix = "AmazonSQS."
+
+type Options struct {
+	QueueOptions func(name string) types.QueueOptions
+	BaseURL      *string
+	AccountID    *string
+	Region       *string
+	PollInterval *time.Duration
+}
+
+func (o *Options) Defaults() *Options {
+	if o.QueueOptions == nil {
+		o.QueueOptions = func(string) types.QueueOptions {
+			return types.QueueOptions{}
+		}
+	}
+	if o.BaseURL == nil {
+		o.BaseURL = common.Ptr("")
+	}
+	if o.AccountID == nil {
+		o.AccountID = common.Ptr("000000000000")
+	}
+	if o.Region == nil {
+		o.Region = common.Ptr("us-east-1")
+	}
+	if o.PollInterval == nil {
+		o.PollInterval = common.Ptr(200 * time.Millisecond)
+	}
+	return o
+}
+
+type apiError struct {
+	status    int
+	code      string
+	queryCode string
+	message   string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func invalidParameter(format string, args ...any) error {
+	return &apiError{status: http.StatusBadRequest, code: "InvalidParameterValue",
+		queryCode: "InvalidParameterValue", message: fmt.Sprintf(format, args...)}
+}
+
+type handler struct {
+	engine  types.Engine
+	options *Options
+	actions map[string]func(request *http.Request, body []byte) (any, error)
+	mutex   sync.Mutex
+	queues  map[string]types.Queue
+}
+
+func NewHandler(engine types.Engine, options ...Options) http.Handler {
+	handlerOptions := common.First(options)
+	p := &handler{
+		engine:  engine,
+		options: handlerOptions.Defaults(),
+		queues:  map[string]types.Queue{},
+	}
+	p.actions = map[string]func(request *http.Request, body []byte) (any, error){
+		"CreateQueue":                  p.createQueue,
+		"GetQueueUrl":                  p.getQueueURL,
+		"ListQueues":                   p.listQueues,
+		"DeleteQueue":                  p.deleteQueue,
+		"PurgeQueue":                   p.purgeQueue,
+		"GetQueueAttributes":           p.getQueueAttributes,
+		"SendMessage":                  p.sendMessage,
+		"SendMessageBatch":             p.sendMessageBatch,
+		"ReceiveMessage":               p.receiveMessage,
+		"DeleteMessage":                p.deleteMessage,
+		"DeleteMessageBatch":           p.deleteMessageBatch,
+		"ChangeMessageVisibility":      p.changeMessageVisibility,
+		"ChangeMessageVisibilityBatch": p.changeMessageVisibilityBatch,
+	}
+	return p
+}
+
+func (p *handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	response, actionErr := p.serve(request)
+	if actionErr != nil {
+		var failure *apiError
+		if !errors.As(actionErr, &failure) {
+			failure = translateError(actionErr)
+		}
+		writer.Header().Set("x-amzn-query-error", failure.queryCode+";Sender")
+		p.write(writer, failure.status, map[string]string{
+			"__type":  "com.amazonaws.sqs#" + failure.code,
+			"message": failure.message,
+		})
+		return
+	}
+	p.write(writer, http.StatusOK, response)
+}
+
+func (p *handler) serve(request *http.Request) (any, error) {
+	if request.Method != http.MethodPost {
+		return nil, &apiError{status: http.StatusMethodNotAllowed, code: "UnsupportedOperation",
+			queryCode: "AWS.SimpleQueueService.UnsupportedOperation", message: "only POST is supported"}
+	}
+
+	target := request.Header.Get("X-Amz-Target")
+	action, found := p.actions[strings.TrimPrefix(target, targetPrefix)]
+	if !found || !strings.HasPrefix(target, targetPrefix) {
+		return nil, &apiError{status: http.StatusBadRequest, code: "UnsupportedOperation",
+			queryCode: "AWS.SimpleQueueService.UnsupportedOperation",
+			message:   fmt.Sprintf("operation %q is not supported", target)}
+	}
+
+	var body json.RawMessage
+	if decodeErr := json.NewDecoder(request.Body).Decode(&body); decodeErr != nil {
+		return nil, &apiError{status: http.StatusBadRequest, code: "InvalidParameterValue",
+			queryCode: "InvalidParameterValue", message: decodeErr.Error()}
+	}
+	return action(request, body)
+}
+
+func (p *handler) write(writer http.ResponseWriter, status int, response any) {
+	writer.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(response)
+}
+
+func translateError(err error) *apiError {
+	switch {
+	case errors.Is(err, types.ErrQueueNotFound):
+		return &apiError{status: http.StatusBadRequest, code: "QueueDoesNotExist",
+			queryCode: "AWS.SimpleQueueService.NonExistentQueue", message: err.Error()}
+	case errors.Is(err, types.ErrInvalidQueueName):
+		return &apiError{status: http.StatusBadRequest, code: "InvalidParameterValue",
+			queryCode: "InvalidParameterValue", message: err.Error()}
+	default:
+		return &apiError{status: http.StatusInternalServerError, code: "InternalError",
+			queryCode: "InternalError", message: err.Error()}
+	}
+}
+
+func (p *handler) queueURL(request *http.Request, name string) string {
+	baseURL := *p.options.BaseURL
+	if baseURL == "" {
+		scheme := "http"
+		if request.TLS != nil {
+			scheme = "https"
+		}
+		baseURL = scheme + "://" + request.Host
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + *p.options.AccountID + "/" + url.PathEscape(name)
+}
+
+func (p *handler) queueName(queueURL string) (string, error) {
+	parsed, parseErr := url.Parse(queueURL)
+	if parseErr != nil || queueURL == "" {
+		return "", &apiError{status: http.StatusBadRequest, code: "InvalidAddress",
+			queryCode: "InvalidAddress", message: fmt.Sprintf("invalid queue URL %q", queueURL)}
+	}
+	return path.Base(parsed.Path), nil
+}
+
+func (p *handler) queue(ctx context.Context, queueURL string) (types.Queue, error) {
+	name, nameErr := p.queueName(queueURL)
+	if nameErr != nil {
+		return nil, nameErr
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if queue, found := p.queues[name]; found {
+		return queue, nil
+	}
+
+	queue, openErr := p.engine.OpenQueue(ctx, name, p.options.QueueOptions(name))
+	if openErr != nil {
+		return nil, openErr
+	}
+	p.queues[name] = queue
+	return queue, nil
+}
+
+func (p *handler) forget(name string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.queues, name)
+}
@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"context"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"maps"
+	"time"
+)
+
+const instrumentationName = "github.com/yunussandikci/dbqueue-go/dbqueue/tracing"
+
+type Options struct {
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
+}
+
+func (o *Options) Defaults() *Options {
+	if o.TracerProvider == nil {
+		o.TracerProvider = otel.GetTracerProvider()
+	}
+	if o.Propagator == nil {
+		o.Propagator = propagation.TraceContext{}
+	}
+	return o
+}
+
+type tracingEngine struct {
+	types.Engine
+	options *Options
+}
+
+type tracingQueue struct {
+	types.Queue
+	name    string
+	tracer  trace.Tracer
+	options *Options
+}
+
+func WrapEngine(engine types.Engine, options ...Options) types.Engine {
+	tracingOptions := common.First(options)
+	return &tracingEngine{
+		Engine:  engine,
+		options: tracingOptions.Defaults(),
+	}
+}
+
+func WrapQueue(queue types.Queue, name string, options ...Options) types.Queue {
+	tracingOptions := common.First(options)
+	tracingOptions.Defaults()
+	return &tracingQueue{
+		Queue:   queue,
+		name:    name,
+		tracer:  tracingOptions.TracerProvider.Tracer(instrumentationName),
+		options: &tracingOptions,
+	}
+}
+
+// Extract returns ctx with the trace context carried by the message, which is the publish span.
+func Extract(ctx context.Context, message types.ReceivedMessage, options ...Options) context.Context {
+	tracingOptions := common.First(options)
+	return tracingOptions.Defaults().Propagator.Extract(ctx, propagation.MapCarrier(message.Attributes))
+}
+
+func (p *tracingEngine) OpenQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	queue, openErr := p.Engine.OpenQueue(ctx, name, options...)
+	if openErr != nil {
+		return nil, openErr
+	}
+	return WrapQueue(queue, name, *p.options), nil
+}
+
+func (p *tracingEngine) CreateQueue(ctx context.Context, name string,
+	options ...types.QueueOptions) (types.Queue, error) {
+	queue, createErr := p.Engine.CreateQueue(ctx, name, options...)
+	if createErr != nil {
+		return nil, createErr
+	}
+	return WrapQueue(queue, name, *p.options), nil
+}
+
+// Consume receives messages from queue like ReceiveMessage, but hands handler a context. When queue is traced, that
+// context carries the process span of the message, so spans started by the handler become its children.
+func Consume(ctx context.Context, queue types.Queue,
+	handler func(ctx context.Context, message types.ReceivedMessage), options types.ReceiveMessageOptions) error {
+	traced, ok := queue.(*tracingQueue)
+	if !ok {
+		return queue.ReceiveMessage(ctx, func(message types.ReceivedMessage) {
+			handler(ctx, message)
+		}, options)
+	}
+	return traced.Queue.ReceiveMessage(ctx, func(message types.ReceivedMessage) {
+		traced.process(ctx, message, handler)
+	}, options)
+}
+
+// ReceiveMessage runs every delivered message in a process span. The handler cannot reach that span, so use Consume
+// to nest handler spans under it.
+func (p *tracingQueue) ReceiveMessage(ctx context.Context, fun func(message types.ReceivedMessage),
+	options types.ReceiveMessageOptions) error {
+	return Consume(ctx, p, func(_ context.Context, message types.ReceivedMessage) {
+		fun(message)
+	}, options)
+}
+
+func (p *tracingQueue) ClaimMessages(ctx context.Context,
+	options types.ReceiveMessageOptions) ([]types.ReceivedMessage, error) {
+	_, messages, claimErr := p.claim(ctx, options)
+	return messages, claimErr
+}
+
+// claim runs one claim in a receive span linked to the publish span of every claimed message. The span is started
+// once the claim returns, since links cannot be added later, and backdated to when the claim began.
+func (p *tracingQueue) claim(ctx context.Context,
+	options types.ReceiveMessageOptions) (context.Context, []types.ReceivedMessage, error) {
+	started := time.Now()
+	messages, claimErr := p.Queue.ClaimMessages(ctx, options)
+
+	var links []trace.Link
+	for _, message := range messages {
+		links = append(links, p.links(message)...)
+	}
+	ctx, span := p.start(ctx, "receive", trace.SpanKindConsumer, trace.WithTimestamp(started),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(messages))))
+	return ctx, messages, finish(span, claimErr)
+}
+
+// process runs handler in a process span linked to the publish span of the message.
+func (p *tracingQueue) process(ctx context.Context, message types.ReceivedMessage,
+	handler func(ctx context.Context, message types.ReceivedMessage)) {
+	ctx, span := p.start(ctx, "process", trace.SpanKindConsumer, trace.WithLinks(p.links(message)...),
+		trace.WithAttributes(attribute.Int64("messaging.message.id", int64(message.ID)),
+			attribute.Int64("messaging.dbqueue.retrieval", int64(message.Retrieval))))
+	defer span.End()
+
+	handler(ctx, message)
+}
+
+// links returns a link to the publish span of the message, if it carries one.
+func (p *tracingQueue) links(message types.ReceivedMessage) []trace.Link {
+	producer := trace.SpanContextFromContext(Extract(context.Background(), message, *p.options))
+	if !producer.IsValid() {
+		return nil
+	}
+	return []trace.Link{{SpanContext: producer}}
+}
+
+func (p *tracingQueue) SendMessage(ctx context.Context, message *types.Message) error {
+	return p.SendMessageBatch(ctx, []*types.Message{message})
+}
+
+func (p *tracingQueue) SendMessageBatch(ctx context.Context, messages []*types.Message) error {
+	ctx, span := p.start(ctx, "publish", trace.SpanKindProducer,
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(messages))))
+
+	traced := make([]*types.Message, len(messages))
+	for i, message := range messages {
+		tracedMessage := *message
+		tracedMessage.Attributes = maps.Clone(message.Attributes)
+		if tracedMessage.Attributes == nil {
+			tracedMessage.Attributes = map[string]string{}
+		}
+		p.options.Propagator.Inject(ctx, propagation.MapCarrier(tracedMessage.Attributes))
+		traced[i] = &tracedMessage
+	}
+
+	return finish(span, p.Queue.SendMessageBatch(ctx, traced))
+}
+
+func (p *tracingQueue) DeleteMessage(ctx context.Context, id uint) error {
+	return p.DeleteMessageBatch(ctx, []uint{id})
+}
+
+func (p *tracingQueue) DeleteMessageBatch(ctx context.Context, ids []uint) error {
+	ctx, span := p.start(ctx, "delete", trace.SpanKindClient,
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(ids))))
+	return finish(span, p.Queue.DeleteMessageBatch(ctx, ids))
+}
+
+func (p *tracingQueue) ChangeMessageVisibility(ctx context.Context, id uint, visibilityTimeout time.Duration) error {
+	return p.ChangeMessageVisibilityBatch(ctx, []uint{id}, visibilityTimeout)
+}
+
+func (p *tracingQueue) ChangeMessageVisibilityBatch(ctx context.Context, ids []uint,
+	visibilityTimeout time.Duration) error {
+	ctx, span := p.start(ctx, "change_visibility", trace.SpanKindClient,
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(ids)),
+			attribute.String("messaging.dbqueue.visibility_timeout", visibilityTimeout.String())))
+	return finish(span, p.Queue.ChangeMessageVisibilityBatch(ctx, ids, visibilityTimeout))
+}
+
+func (p *tracingQueue) start(ctx context.Context, operation string, kind trace.SpanKind,
+	options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	options = append(options, trace.WithSpanKind(kind), trace.WithAttributes(
+		attribute.String("messaging.system", "dbqueue"),
+		attribute.String("messaging.destination.name", p.name),
+		attribute.String("messaging.operation", operation)))
+	return p.tracer.Start(ctx, p.name+" "+operation, options...)
+}
+
+func finish(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return err
+}
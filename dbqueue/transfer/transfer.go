@@ -0,0 +1,262 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"github.com/yunussandikci/dbqueue-go/dbqueue/types"
+	"io"
+	"time"
+)
+
+type Summary struct {
+	Count    int
+	Checksum uint64
+}
+
+func (s *Summary) add(record types.ExportRecord) error {
+	record.ID = 0
+	encoded, marshalErr := json.Marshal(record)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	sum := sha256.Sum256(encoded)
+	s.Count++
+	s.Checksum += binary.BigEndian.Uint64(sum[:8])
+	return nil
+}
+
+// Result reports a transfer. InFlight counts the records a move left in the source because a consumer claimed them
+// after they were exported.
+type Result struct {
+	Summary
+	LastID   uint
+	Skipped  int
+	Excluded int
+	InFlight int
+}
+
+// Options configure a transfer. Select, when set, limits the transfer to the records it returns true for; the
+// others stay in the source and are counted as Excluded. A move hides every record for at least LockTimeout while it
+// copies it, so a record must be deleted within that time.
+type Options struct {
+	Move        *bool
+	BatchSize   *int
+	LockTimeout *time.Duration
+	Resume      *Result
+	Select      func(record types.ExportRecord) bool
+	Progress    func(result Result)
+}
+
+func (o *Options) Defaults() *Options {
+	if o.Move == nil {
+		o.Move = common.Ptr(false)
+	}
+	if o.BatchSize == nil {
+		o.BatchSize = common.Ptr(500)
+	}
+	if o.LockTimeout == nil {
+		o.LockTimeout = common.Ptr(5 * time.Minute)
+	}
+	return o
+}
+
+func Transfer(ctx context.Context, source, destination types.Queue, options ...Options) (*Result, error) {
+	transferOptions := common.First(options)
+	transferOptions.Defaults()
+
+	if *transferOptions.BatchSize < 1 {
+		return nil, fmt.Errorf("%w: batch size must be positive", types.ErrInvalidTransfer)
+	}
+
+	var result Result
+	if transferOptions.Resume != nil {
+		result = *transferOptions.Resume
+	}
+
+	for {
+		var batch bytes.Buffer
+		exported, exportErr := source.Export(ctx, &batch, types.ExportOptions{
+			AfterID: common.Ptr(result.LastID),
+			Limit:   transferOptions.BatchSize,
+		})
+		if exportErr != nil {
+			return &result, exportErr
+		}
+		if exported == 0 {
+			return &result, nil
+		}
+
+		records, lastID, decodeErr := decodeBatch(&batch, transferOptions.Select)
+		if decodeErr != nil {
+			return &result, decodeErr
+		}
+		result.Excluded += exported - len(records)
+
+		if *transferOptions.Move {
+			locked, lockErr := lock(ctx, source, records, *transferOptions.LockTimeout)
+			if lockErr != nil {
+				return &result, lockErr
+			}
+			result.InFlight += len(records) - len(locked)
+			records = locked
+		}
+
+		summary, selected, encodeErr := encodeBatch(records)
+		if encodeErr != nil {
+			return &result, encodeErr
+		}
+
+		if len(records) > 0 {
+			imported, importErr := destination.Import(ctx, selected, types.ImportOptions{
+				BatchSize: transferOptions.BatchSize,
+			})
+			if importErr != nil {
+				return &result, importErr
+			}
+			result.Skipped += len(records) - imported
+
+			if *transferOptions.Move {
+				expired, deleteErr := remove(ctx, source, records)
+				if deleteErr != nil {
+					return &result, deleteErr
+				}
+				result.InFlight += expired
+			}
+		}
+
+		result.Count += summary.Count
+		result.Checksum += summary.Checksum
+		result.LastID = lastID
+		if transferOptions.Progress != nil {
+			transferOptions.Progress(result)
+		}
+
+		if exported < *transferOptions.BatchSize {
+			return &result, nil
+		}
+	}
+}
+
+func Checksum(ctx context.Context, queue types.Queue) (*Summary, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		_, exportErr := queue.Export(ctx, writer)
+		writer.CloseWithError(exportErr)
+	}()
+
+	var summary Summary
+	decoder := json.NewDecoder(reader)
+	for {
+		var record types.ExportRecord
+		decodeErr := decoder.Decode(&record)
+		if errors.Is(decodeErr, io.EOF) {
+			return &summary, nil
+		}
+		if decodeErr == nil {
+			decodeErr = summary.add(record)
+		}
+		if decodeErr != nil {
+			reader.CloseWithError(decodeErr)
+			return nil, decodeErr
+		}
+	}
+}
+
+func Verify(ctx context.Context, expected *Summary, queue types.Queue) error {
+	actual, checksumErr := Checksum(ctx, queue)
+	if checksumErr != nil {
+		return checksumErr
+	}
+
+	if *actual != *expected {
+		return fmt.Errorf("%w: expected %d messages with checksum %016x, found %d with checksum %016x",
+			types.ErrTransferMismatch, expected.Count, expected.Checksum, actual.Count, actual.Checksum)
+	}
+	return nil
+}
+
+// decodeBatch returns the records of an export batch that pass selection, and the last exported ID.
+func decodeBatch(reader io.Reader, selectRecord func(record types.ExportRecord) bool) ([]types.ExportRecord, uint,
+	error) {
+	var (
+		records []types.ExportRecord
+		lastID  uint
+	)
+	decoder := json.NewDecoder(reader)
+	for {
+		var record types.ExportRecord
+		decodeErr := decoder.Decode(&record)
+		if errors.Is(decodeErr, io.EOF) {
+			return records, lastID, nil
+		}
+		if decodeErr != nil {
+			return nil, 0, decodeErr
+		}
+		lastID = record.ID
+		if selectRecord == nil || selectRecord(record) {
+			records = append(records, record)
+		}
+	}
+}
+
+func encodeBatch(records []types.ExportRecord) (*Summary, *bytes.Buffer, error) {
+	var (
+		summary Summary
+		encoded bytes.Buffer
+	)
+	encoder := json.NewEncoder(&encoded)
+	for _, record := range records {
+		if encodeErr := encoder.Encode(record); encodeErr != nil {
+			return nil, nil, encodeErr
+		}
+		if addErr := summary.add(record); addErr != nil {
+			return nil, nil, addErr
+		}
+	}
+	return &summary, &encoded, nil
+}
+
+// lock hides each record for at least timeout, or until it was due anyway, but only while it still has the retrieval
+// count it was exported with. A record a consumer claimed after the export no longer matches and is left out.
+func lock(ctx context.Context, queue types.Queue, records []types.ExportRecord,
+	timeout time.Duration) ([]types.ExportRecord, error) {
+	var locked []types.ExportRecord
+	for _, record := range records {
+		hidden, rescheduleErr := queue.RescheduleWhere(ctx, exported(record),
+			max(timeout, time.Until(time.Unix(record.VisibleAfter, 0))))
+		if rescheduleErr != nil {
+			return nil, rescheduleErr
+		}
+		if hidden > 0 {
+			locked = append(locked, record)
+		}
+	}
+	return locked, nil
+}
+
+// remove deletes the locked records and returns how many had been claimed again after their lock expired. Those
+// now exist in both queues.
+func remove(ctx context.Context, queue types.Queue, records []types.ExportRecord) (int, error) {
+	var expired int
+	for _, record := range records {
+		deleted, deleteErr := queue.DeleteWhere(ctx, exported(record))
+		if deleteErr != nil {
+			return expired, deleteErr
+		}
+		if deleted == 0 {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func exported(record types.ExportRecord) types.MessageFilter {
+	return types.MessageFilter{ID: common.Ptr(record.ID), Retrieval: common.Ptr(record.Retrieval)}
+}
@@ -3,8 +3,15 @@ package types
 import "context"
 
 type Engine interface {
-	OpenQueue(ctx context.Context, name string) (Queue, error)
-	CreateQueue(ctx context.Context, name string) (Queue, error)
-	DeleteQueue(ctx context.Context, name string) error
-	PurgeQueue(ctx context.Context, name string) error
+	OpenQueue(ctx context.Context, name string, options ...QueueOptions) (Queue, error)
+	CreateQueue(ctx context.Context, name string, options ...QueueOptions) (Queue, error)
+	DeleteQueue(ctx context.Context, name string, options ...QueueOptions) error
+	PurgeQueue(ctx context.Context, name string, options ...QueueOptions) error
+	MigrateQueue(ctx context.Context, name string) error
+	MigrateAll(ctx context.Context) error
+	ListQueues(ctx context.Context) ([]string, error)
+	RateLimiter(ctx context.Context, name string, rate float64, burst int) (RateLimiter, error)
+	Ping(ctx context.Context) error
+	Health(ctx context.Context) (*Health, error)
+	Close() error
 }
@@ -3,6 +3,30 @@ package types
 import "errors"
 
 var (
-	ErrQueueNotFound        = errors.New("queue not found")
-	ErrDatabaseNotSupported = errors.New("database not supported")
+	ErrInvalidQueueName               = errors.New("invalid queue name")
+	ErrNULNotSupported                = errors.New("text values must not contain NUL characters on this database")
+	ErrQueueNotFound                  = errors.New("queue not found")
+	ErrDatabaseNotSupported           = errors.New("database not supported")
+	ErrCompressionNotSupported        = errors.New("compression not supported")
+	ErrPayloadTooLarge                = errors.New("decompressed payload exceeds the size limit")
+	ErrEncryptionKeyNotFound          = errors.New("encryption key not found")
+	ErrKeyProviderNotConfigured       = errors.New("key provider not configured")
+	ErrMalformedEncryptedPayload      = errors.New("malformed encrypted payload")
+	ErrBlobNotFound                   = errors.New("blob not found")
+	ErrBlobStoreNotConfigured         = errors.New("blob store not configured")
+	ErrInvalidBlobKey                 = errors.New("invalid blob key")
+	ErrInvalidQueueSchema             = errors.New("table does not have the queue schema")
+	ErrQueueSchemaOutdated            = errors.New("queue schema is outdated, migrate the queue")
+	ErrQueueSchemaVersionNotSupported = errors.New("queue schema version not supported")
+	ErrMigrationLockTimeout           = errors.New("timed out waiting for the migration lock")
+	ErrHandlerPanicked                = errors.New("message handler panicked")
+	ErrInvalidRateLimit               = errors.New("rate limit must have a positive rate and a burst of at least one")
+	ErrInvalidConsumer                = errors.New("invalid consumer configuration")
+	ErrPoolOptionNotSupported         = errors.New("pool option not supported on this database")
+	ErrInvalidPriorityAging           = errors.New("priority aging interval must be at least one second")
+	ErrInvalidMessageFilter           = errors.New("invalid message filter")
+	ErrExportVersionNotSupported      = errors.New("export format version not supported")
+	ErrInvalidTransfer                = errors.New("invalid transfer configuration")
+	ErrTransferMismatch               = errors.New("transferred messages do not match")
+	ErrInjectedFault                  = errors.New("injected fault")
 )
@@ -0,0 +1,43 @@
+package types
+
+import "github.com/yunussandikci/dbqueue-go/dbqueue/common"
+
+const ExportFormatVersion = 1
+
+type ExportRecord struct {
+	Version         int               `json:"version"`
+	ID              uint              `json:"id"`
+	Payload         []byte            `json:"payload"`
+	Priority        uint32            `json:"priority"`
+	DeduplicationID *string           `json:"deduplication_id,omitempty"`
+	VisibleAfter    int64             `json:"visible_after"`
+	Retrieval       uint32            `json:"retrieval"`
+	CreatedAt       int64             `json:"created_at"`
+	Attributes      map[string]string `json:"attributes,omitempty"`
+}
+
+type ExportOptions struct {
+	AfterID *uint
+	Limit   *int
+}
+
+func (e *ExportOptions) Defaults() *ExportOptions {
+	if e.AfterID == nil {
+		e.AfterID = common.Ptr[uint](0)
+	}
+	if e.Limit == nil {
+		e.Limit = common.Ptr(0)
+	}
+	return e
+}
+
+type ImportOptions struct {
+	BatchSize *int
+}
+
+func (i *ImportOptions) Defaults() *ImportOptions {
+	if i.BatchSize == nil {
+		i.BatchSize = common.Ptr(500)
+	}
+	return i
+}
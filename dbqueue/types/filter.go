@@ -0,0 +1,31 @@
+package types
+
+import "github.com/yunussandikci/dbqueue-go/dbqueue/common"
+
+// MessageFilter selects messages by their fields. ID and Retrieval together match one delivery of a message, so
+// callers holding a message from an earlier claim or export only act on it while nobody has claimed it since.
+type MessageFilter struct {
+	ID                    *uint
+	MinPriority           *uint32
+	MaxPriority           *uint32
+	CreatedAfter          *int64
+	CreatedBefore         *int64
+	RetrievalAbove        *uint32
+	Retrieval             *uint32
+	Attributes            map[string]string
+	DeduplicationIDPrefix *string
+	ChunkSize             *int
+}
+
+func (f *MessageFilter) Defaults() *MessageFilter {
+	if f.ChunkSize == nil {
+		f.ChunkSize = common.Ptr(500)
+	}
+	return f
+}
+
+func (f *MessageFilter) Empty() bool {
+	return f.ID == nil && f.MinPriority == nil && f.MaxPriority == nil && f.CreatedAfter == nil &&
+		f.CreatedBefore == nil && f.RetrievalAbove == nil && f.Retrieval == nil && len(f.Attributes) == 0 &&
+		f.DeduplicationIDPrefix == nil
+}
@@ -0,0 +1,15 @@
+package types
+
+import "time"
+
+type Health struct {
+	Latency  time.Duration
+	Registry bool
+	Queues   []QueueHealth
+}
+
+type QueueHealth struct {
+	Name    string
+	Version int
+	Err     error
+}
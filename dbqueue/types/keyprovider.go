@@ -0,0 +1,8 @@
+package types
+
+import "context"
+
+type KeyProvider interface {
+	CurrentKey(ctx context.Context) (string, []byte, error)
+	Key(ctx context.Context, id string) ([]byte, error)
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"fmt"
 	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
 	"time"
 )
@@ -10,6 +11,7 @@ type Message struct {
 	Priority        uint32
 	DeduplicationID *string
 	VisibleAfter    *int64
+	Attributes      map[string]string
 }
 
 type ReceivedMessage struct {
@@ -19,10 +21,28 @@ type ReceivedMessage struct {
 	CreatedAt int64
 }
 
+// DecodeError reports a claimed message whose payload or attributes could not be decoded. The message stays
+// claimed until its visibility timeout expires, so it can be deleted or rescheduled by ID meanwhile.
+type DecodeError struct {
+	ID        uint
+	Retrieval uint32
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("message %d: %v", e.ID, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
 type ReceiveMessageOptions struct {
 	MaxNumberOfMessages *int
 	VisibilityTimeout   *time.Duration
 	WaitTime            *time.Duration
+	RateLimiter         RateLimiter
+	OnDecodeError       func(err *DecodeError)
 }
 
 func (r *ReceiveMessageOptions) Defaults() *ReceiveMessageOptions {
@@ -37,3 +57,11 @@ func (r *ReceiveMessageOptions) Defaults() *ReceiveMessageOptions {
 	}
 	return r
 }
+
+// minPollInterval keeps a zero WaitTime from claiming in a busy loop.
+const minPollInterval = 100 * time.Millisecond
+
+// PollInterval is how long ReceiveMessage waits after an empty claim: WaitTime, but at least 100 milliseconds.
+func (r *ReceiveMessageOptions) PollInterval() time.Duration {
+	return max(*r.WaitTime, minPollInterval)
+}
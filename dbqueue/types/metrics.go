@@ -0,0 +1,37 @@
+package types
+
+import "time"
+
+type Metrics interface {
+	MessagesSent(queue string, count int)
+	MessagesReceived(queue string, count int)
+	MessagesDeleted(queue string, count int)
+	MessagesDeduplicated(queue string, count int)
+	MessagesRetried(queue string, count int)
+	MessagesDeadLettered(queue string, count int)
+	HandlerDuration(queue string, duration time.Duration)
+	EndToEndLatency(queue string, latency time.Duration)
+	ClaimDuration(queue string, duration time.Duration)
+	QueueDepth(queue string, depth int)
+	OldestMessageAge(queue string, age time.Duration)
+}
+
+type NopMetrics struct{}
+
+func (NopMetrics) MessagesSent(string, int)               {}
+func (NopMetrics) MessagesReceived(string, int)           {}
+func (NopMetrics) MessagesDeleted(string, int)            {}
+func (NopMetrics) MessagesDeduplicated(string, int)       {}
+func (NopMetrics) MessagesRetried(string, int)            {}
+func (NopMetrics) MessagesDeadLettered(string, int)       {}
+func (NopMetrics) HandlerDuration(string, time.Duration)  {}
+func (NopMetrics) EndToEndLatency(string, time.Duration)  {}
+func (NopMetrics) ClaimDuration(string, time.Duration)    {}
+func (NopMetrics) QueueDepth(string, int)                 {}
+func (NopMetrics) OldestMessageAge(string, time.Duration) {}
+
+type QueueStats struct {
+	Depth     int
+	Visible   int
+	OldestAge time.Duration
+}
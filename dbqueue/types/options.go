@@ -0,0 +1,76 @@
+package types
+
+import (
+	"github.com/yunussandikci/dbqueue-go/dbqueue/common"
+	"io"
+	"log/slog"
+	"time"
+)
+
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+type QueueOptions struct {
+	Compression          *Compression
+	CompressionThreshold *int
+	DecompressionLimit   *int
+	KeyProvider          KeyProvider
+	BlobStore            BlobStore
+	BlobThreshold        *int
+	PriorityAging        *time.Duration
+}
+
+func (q *QueueOptions) Defaults() *QueueOptions {
+	if q.Compression == nil {
+		q.Compression = common.Ptr(CompressionNone)
+	}
+	if q.CompressionThreshold == nil {
+		q.CompressionThreshold = common.Ptr(1024)
+	}
+	if q.DecompressionLimit == nil {
+		q.DecompressionLimit = common.Ptr(64 * 1024 * 1024)
+	}
+	if q.BlobThreshold == nil {
+		q.BlobThreshold = common.Ptr(256 * 1024)
+	}
+	return q
+}
+
+type Dialect string
+
+const (
+	DialectMySQL  Dialect = "mysql"
+	DialectSQLite Dialect = "sqlite"
+)
+
+type EngineOptions struct {
+	Schema             *string
+	MaxOpenConns       *int
+	MaxIdleConns       *int
+	ConnMaxLifetime    *time.Duration
+	ConnMaxIdleTime    *time.Duration
+	Metrics            Metrics
+	Logger             *slog.Logger
+	SlowQueryThreshold *time.Duration
+}
+
+func (e *EngineOptions) Defaults() *EngineOptions {
+	if e.Schema == nil {
+		e.Schema = common.Ptr("dbqueue")
+	}
+	if e.Metrics == nil {
+		e.Metrics = NopMetrics{}
+	}
+	if e.Logger == nil {
+		e.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if e.SlowQueryThreshold == nil {
+		e.SlowQueryThreshold = common.Ptr(time.Second)
+	}
+	return e
+}
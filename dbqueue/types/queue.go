@@ -2,15 +2,23 @@ package types
 
 import (
 	"context"
+	"io"
 	"time"
 )
 
 type Queue interface {
 	ReceiveMessage(ctx context.Context, fun func(message ReceivedMessage), options ReceiveMessageOptions) error
+	ClaimMessages(ctx context.Context, options ReceiveMessageOptions) ([]ReceivedMessage, error)
 	SendMessage(ctx context.Context, message *Message) error
 	SendMessageBatch(ctx context.Context, messages []*Message) error
 	DeleteMessage(ctx context.Context, id uint) error
 	DeleteMessageBatch(ctx context.Context, ids []uint) error
 	ChangeMessageVisibility(ctx context.Context, id uint, visibilityTimeout time.Duration) error
 	ChangeMessageVisibilityBatch(ctx context.Context, ids []uint, visibilityTimeout time.Duration) error
+	DeleteWhere(ctx context.Context, filter MessageFilter) (int, error)
+	RescheduleWhere(ctx context.Context, filter MessageFilter, visibilityTimeout time.Duration) (int, error)
+	Export(ctx context.Context, w io.Writer, options ...ExportOptions) (int, error)
+	Import(ctx context.Context, r io.Reader, options ...ImportOptions) (int, error)
+	ReEncrypt(ctx context.Context) (int, error)
+	Stats(ctx context.Context) (*QueueStats, error)
 }
@@ -0,0 +1,8 @@
+package types
+
+import "context"
+
+type RateLimiter interface {
+	Acquire(ctx context.Context, max int) (int, error)
+	Release(ctx context.Context, count int) error
+}